@@ -2,51 +2,35 @@ package main
 
 import (
 	"encoding/base64"
-	"log"
 	"net/http"
-	"os"
-	"path"
 	"strconv"
-	"strings"
-	"text/template"
 
 	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/barcode"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	barcodeMinSize = 16
+	barcodeMaxSize = 480
+)
+
 type barcodeContent struct {
 	Type    string
 	Content string
 	Size    string
 }
 
-func serveBarcode(c echo.Context) error {
-	p := c.Request().URL.Path
-
-	_, file := path.Split(p)
-	if file == "" || file == "barcode" {
-		file = "index.wml"
-	}
-
-	f, err := os.Open("./static/barcode/" + file)
-
-	if err == os.ErrNotExist {
-		return c.String(http.StatusNotFound, "")
-	} else if err != nil {
-		log.Panicln(err)
-		return c.String(http.StatusInternalServerError, "")
-	}
-
-	mime := "text/vnd.wap.wml"
-	if strings.HasSuffix(file, ".wbmp") {
-		mime = "image/vnd.wap.wbmp"
-	}
+// barcodeService draws barcodes, it keeps no state but is wired like the other sections
+type barcodeService struct {
+	renderer *render.Renderer
+}
 
-	return c.Stream(http.StatusOK, mime, f)
+func newBarcodeService(renderer *render.Renderer) *barcodeService {
+	return &barcodeService{renderer: renderer}
 }
 
-func serveBarcodePage(c echo.Context) error {
-	tmpl := template.Must(template.ParseFiles("./static/barcode/barcode.wml"))
+func (s *barcodeService) servePage(c echo.Context) error {
 	content := base64.StdEncoding.EncodeToString([]byte(c.QueryParam("c")))
 
 	pageContent := barcodeContent{
@@ -55,12 +39,10 @@ func serveBarcodePage(c echo.Context) error {
 		Content: content,
 	}
 
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-
-	return tmpl.Execute(c.Response().Writer, pageContent)
+	return s.renderer.Render(c, "barcode/barcode.wml", pageContent)
 }
 
-func serveBarcodeImage(c echo.Context) error {
+func (s *barcodeService) serveImage(c echo.Context) error {
 	content, err := base64.StdEncoding.DecodeString(c.QueryParam("c"))
 	if err != nil {
 		return c.String(http.StatusBadRequest, "Invalid content")
@@ -75,13 +57,23 @@ func serveBarcodeImage(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Invalid size")
 	}
 
-	if c.QueryParam("t") == "qr" {
-		return c.Blob(http.StatusOK, "image/vnd.wap.wbmp", barcode.CreateQR(string(content), size))
-	} else if c.QueryParam("t") == "aztec" {
-		return c.Blob(http.StatusOK, "image/vnd.wap.wbmp", barcode.CreateAztec(string(content), size))
-	} else if c.QueryParam("t") == "code128" {
-		return c.Blob(http.StatusOK, "image/vnd.wap.wbmp", barcode.CreateCode128(string(content), size))
+	// the size is user supplied, keep it to something a phone can show and we can draw cheaply
+	size = min(max(size, barcodeMinSize), barcodeMaxSize)
+
+	var out []byte
+	switch c.QueryParam("t") {
+	case "qr":
+		out, err = barcode.CreateQR(string(content), size)
+	case "aztec":
+		out, err = barcode.CreateAztec(string(content), size)
+	case "code128":
+		out, err = barcode.CreateCode128(string(content), size)
+	default:
+		return nil
+	}
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid barcode: "+err.Error())
 	}
 
-	return nil
+	return c.Blob(http.StatusOK, "image/vnd.wap.wbmp", out)
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wbmp"
+	"github.com/labstack/echo/v4"
+)
+
+func TestServeBarcodeSize(t *testing.T) {
+	s := &barcodeService{}
+	e := echo.New()
+
+	tests := []struct {
+		size  string
+		width int
+	}{
+		{"-1", barcodeMinSize},
+		{"0", barcodeMinSize},
+		{"100", 100},
+		{"100000000", barcodeMaxSize},
+	}
+	for _, tt := range tests {
+		// aztec fits the smallest size, a QR code does not
+		req := httptest.NewRequest(http.MethodGet, "/barcode.wbmp?t=aztec&c=aGVsbG8%3D&s="+tt.size, nil)
+		rec := httptest.NewRecorder()
+		if err := s.serveImage(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("s=%s: status %d %q", tt.size, rec.Code, rec.Body.String())
+			continue
+		}
+		cfg, err := wbmp.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("s=%s: %v", tt.size, err)
+		}
+		if cfg.Width != tt.width {
+			t.Errorf("s=%s is %d pixels wide, want %d", tt.size, cfg.Width, tt.width)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/barcode.wbmp?t=qr&c=aGVsbG8%3D&s=0", nil)
+	rec := httptest.NewRecorder()
+	if err := s.serveImage(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("QR code smaller than its modules: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/charset"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/labstack/echo/v4"
+)
+
+// transcodeDeck sends text decks in the charset the phone negotiated,
+// compiled decks carry their charset in the WBXML header instead
+func transcodeDeck(c echo.Context, deck []byte) ([]byte, error) {
+	header := c.Response().Header()
+	if !strings.HasPrefix(header.Get("Content-Type"), render.ContentType) {
+		return deck, nil
+	}
+
+	cs := deviceProfile(c).Charset
+	header.Set("Content-Type", render.ContentType+"; charset="+cs)
+	return charset.EncodeDeck(deck, cs), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/linkcache"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// continuations are read right after the first deck, an hour covers a slow reader
+	continuationTTL           = time.Hour
+	continuationMaxBytes      = 32 << 20
+	continuationSweepInterval = 5 * time.Minute
+)
+
+// deckSplitter breaks decks larger than the phone's deck limit into a chain of decks,
+// the first one is sent right away and the rest is served by serveMore.
+// The whole deck is kept in memory and split again for every continuation,
+// behind a load balancer /more needs the replica that rendered the deck
+type deckSplitter struct {
+	continuations *linkcache.MemoryStore
+	renderer      *render.Renderer
+}
+
+// newDeckSplitter creates the continuation store, its IDs are keyed like the
+// link store so they cannot be guessed. It is swept until ctx is done
+func newDeckSplitter(ctx context.Context, cfg config.Links, renderer *render.Renderer) *deckSplitter {
+	ids := linkcache.NewIDGenerator([]byte(cfg.Key))
+	continuations := linkcache.NewMemoryStore(ids, continuationTTL, 0, continuationMaxBytes)
+	linkcache.StartSweeper(ctx, continuations, continuationSweepInterval)
+
+	return &deckSplitter{continuations: continuations, renderer: renderer}
+}
+
+// pageURL points at the continuation decks of the deck stored under id
+func pageURL(id string) wml.PageURL {
+	return func(page int) string {
+		return fmt.Sprintf("/more?id=%s&p=%d", id, page)
+	}
+}
+
+func (d *deckSplitter) splitDeck(c echo.Context, deck []byte) ([]byte, error) {
+	doc, err := wml.Parse(bytes.NewReader(deck))
+	if err != nil {
+		return deck, err
+	}
+
+	// only decks that need splitting are stored
+	var id string
+	var storeErr error
+	pages := wml.Split(doc, deviceProfile(c).MaxDeckSize, compiledSize, func(page int) string {
+		if id == "" && storeErr == nil {
+			id, storeErr = d.continuations.Store(packDeck(deck))
+		}
+		return pageURL(id)(page)
+	})
+	if pages == nil {
+		return deck, nil
+	}
+	if storeErr != nil {
+		return deck, fmt.Errorf("could not store the continuations of a %d byte deck: %w", len(deck), storeErr)
+	}
+
+	// continuations expire, never let the phone cache this
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+	return pages[0].Bytes(), nil
+}
+
+func (d *deckSplitter) serveMore(c echo.Context) error {
+	page, err := strconv.Atoi(c.QueryParam("p"))
+	if err != nil {
+		return serveErrorCard(c, d.renderer, http.StatusBadRequest, "Invalid page.")
+	}
+
+	id := c.QueryParam("id")
+	packed, err := d.continuations.Get(id)
+	if err != nil || packed == "" {
+		return serveErrorCard(c, d.renderer, http.StatusNotFound, "This page has expired, please go back and reload it.")
+	}
+	deck, err := unpackDeck(packed)
+	if err != nil {
+		return serveErrorCard(c, d.renderer, http.StatusNotFound, "This page has expired, please go back and reload it.")
+	}
+
+	doc, err := wml.Parse(bytes.NewReader(deck))
+	if err != nil {
+		return serveErrorCard(c, d.renderer, http.StatusNotFound, "This page has expired, please go back and reload it.")
+	}
+	pages := wml.Split(doc, deviceProfile(c).MaxDeckSize, compiledSize, pageURL(id))
+	if page < 0 || page >= len(pages) {
+		return serveErrorCard(c, d.renderer, http.StatusNotFound, "This page has expired, please go back and reload it.")
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+	return c.Blob(http.StatusOK, "text/vnd.wap.wml", pages[page].Bytes())
+}
+
+// packDeck deflates a deck for the continuation store, decks compress well
+func packDeck(deck []byte) string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(deck)
+	w.Close()
+	return buf.String()
+}
+
+func unpackDeck(packed string) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader([]byte(packed))))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/device"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/safefetch"
+	"github.com/labstack/echo/v4"
+)
+
+// newDeviceDetector creates the device detector, UAProf documents are kept in cfg.UAProfCacheDir
+func newDeviceDetector(cfg config.Devices) (*device.Detector, error) {
+	return device.NewDetector(cfg.UAProfCacheDir, safefetch.New(safefetch.DefaultOptions))
+}
+
+// deviceMiddleware attaches the profile of the requesting phone to the context
+func deviceMiddleware(devices *device.Detector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("device", devices.Detect(c.Request()))
+			return next(c)
+		}
+	}
+}
+
+// deviceProfile returns the profile of the phone making the request
+func deviceProfile(c echo.Context) device.Profile {
+	if p, ok := c.Get("device").(device.Profile); ok {
+		return p
+	}
+	return device.Default
+}
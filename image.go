@@ -1,47 +1,220 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/device"
 	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/image"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/imagecache"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/safefetch"
 	"github.com/labstack/echo/v4"
 )
 
-func serveImage(c echo.Context) error {
+// ditherOptions reads the dithering query parameters, names are kept short
+// as the whole URL has to fit the ~100 byte limit of older phones
+//
+//	d: dithering algorithm (fs, atkinson, bayer2, bayer4, bayer8, threshold)
+//	l: threshold level for d=threshold, empty picks one automatically
+//	c: contrast factor, e.g. 1.5
+//	g: gamma, e.g. 1.2 to brighten
+func ditherOptions(c echo.Context) (image.DitherOptions, error) {
+	opts := image.DefaultDitherOptions
+	if algorithm, ok := image.ParseAlgorithm(deviceProfile(c).Dither); ok {
+		opts.Algorithm = algorithm
+	}
+
+	if d := c.QueryParam("d"); d != "" {
+		algorithm, ok := image.ParseAlgorithm(d)
+		if !ok {
+			return opts, errors.New("unknown dithering algorithm")
+		}
+		opts.Algorithm = algorithm
+	}
+
+	if l := c.QueryParam("l"); l != "" {
+		level, err := strconv.ParseUint(l, 10, 8)
+		if err != nil {
+			return opts, errors.New("invalid threshold level")
+		}
+		opts.Level = uint8(level)
+	}
+
+	var err error
+	if contrast := c.QueryParam("c"); contrast != "" {
+		opts.Contrast, err = strconv.ParseFloat(contrast, 64)
+		if err != nil || opts.Contrast < 0 {
+			return opts, errors.New("invalid contrast")
+		}
+	}
+
+	if gamma := c.QueryParam("g"); gamma != "" {
+		opts.Gamma, err = strconv.ParseFloat(gamma, 64)
+		if err != nil || opts.Gamma < 0 {
+			return opts, errors.New("invalid gamma")
+		}
+	}
+
+	// every distinct value is another cache entry, keep them to what makes a visible difference
+	return opts.Normalize(), nil
+}
+
+const (
+	imageWidth         = 80
+	imageCacheMemory   = 16 << 20
+	imageCacheMaxAge   = 24 * time.Hour
+	imageSweepInterval = 10 * time.Minute
+)
+
+// imageService converts remote images for phones
+type imageService struct {
+	// fetcher downloads the images we convert, the url parameter is user supplied
+	// so it must never reach our own network
+	fetcher *safefetch.Fetcher
+
+	// cache holds converted images so popular articles do not hit the origin on every view
+	cache *imagecache.Cache
+
+	// links resolves the cache: URLs of article images
+	links    *linkService
+	renderer *render.Renderer
+}
+
+// newImageService creates the converted image cache in cfg.CacheDir,
+// it is swept until ctx is done
+func newImageService(ctx context.Context, cfg config.Images, renderer *render.Renderer, links *linkService) (*imageService, error) {
+	cache, err := imagecache.New(cfg.CacheDir, imageCacheMemory, int64(cfg.CacheMaxMB)<<20, imageCacheMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	imagecache.StartSweeper(ctx, cache, imageSweepInterval)
+
+	return &imageService{
+		fetcher:  safefetch.New(safefetch.DefaultOptions),
+		cache:    cache,
+		links:    links,
+		renderer: renderer,
+	}, nil
+}
+
+func (s *imageService) serveImage(c echo.Context) error {
 	imageURL := c.QueryParam("url")
 	if imageURL == "" {
-		return c.String(http.StatusBadRequest, "no URL provided")
+		return s.serveImageError(c, http.StatusBadRequest, errors.New("no URL provided"))
+	}
+
+	opts, err := ditherOptions(c)
+	if err != nil {
+		return s.serveImageError(c, http.StatusBadRequest, err)
 	}
 
 	if strings.HasPrefix(imageURL, "cache:") {
-		imageURL = GetLink(imageURL[6:])
+		imageURL = s.links.get(linkURL, imageURL[6:])
 		if imageURL == "" {
-			return c.String(http.StatusBadRequest, "invalid cache link")
+			return s.serveImageError(c, http.StatusNotFound, errors.New("invalid cache link"))
 		}
 	}
 
-	// download the image
-	resp, err := http.Get(imageURL)
+	profile := deviceProfile(c)
+	key := imagecache.Key{URL: imageURL, Format: "wbmp", Size: int64(min(imageWidth, profile.ScreenWidth)), Dither: opts.String()}
+	mime := "image/vnd.wap.wbmp"
+	if profile.Color && profile.JPEG {
+		key.Format, key.Dither = "jpeg", ""
+		mime = "image/jpeg"
+	}
+
+	c.Response().Header().Set("ETag", key.ETag())
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+	// the format and dithering depend on the phone
+	c.Response().Header().Add("Vary", device.Vary)
+
+	// the ETag only depends on the conversion, gateways can revalidate without us fetching anything
+	if c.Request().Header.Get("If-None-Match") == key.ETag() {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	entry, ok := s.cache.Get(key)
+	if !ok {
+		out, err := s.convertImage(c.Request().Context(), imageURL, key.Format, key.Size, opts)
+		if err != nil {
+			return s.serveImageError(c, imageErrorStatus(err), err)
+		}
+		entry, err = s.cache.Put(key, out)
+		if err != nil {
+			log.Println("failed to cache image:", err)
+		}
+	}
+
+	c.Response().Header().Set("Last-Modified", entry.Modified.UTC().Format(http.TimeFormat))
+	if since, err := http.ParseTime(c.Request().Header.Get("If-Modified-Since")); err == nil && !entry.Modified.After(since) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.Blob(http.StatusOK, mime, entry.Data)
+}
+
+// convertImage downloads the image at imageURL and converts it to format, size pixels wide
+func (s *imageService) convertImage(ctx context.Context, imageURL, format string, size int64, opts image.DitherOptions) ([]byte, error) {
+	resp, err := s.fetcher.Get(ctx, imageURL)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "")
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	// check if content type is image
-	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image") {
-		log.Println(resp.Header.Get("Content-Type"))
-		return c.String(http.StatusBadRequest, "")
+	if !strings.HasPrefix(resp.ContentType, "image") {
+		return nil, fmt.Errorf("%w: %s", errNotAnImage, resp.ContentType)
+	}
+
+	if format == "jpeg" {
+		return image.ImageToJPEG(resp.Body, size)
+	}
+	return image.ImageToWBMP(resp.Body, size, opts)
+}
+
+var errNotAnImage = errors.New("not an image")
+
+// imageErrorStatus maps fetch and conversion errors onto HTTP status codes
+func imageErrorStatus(err error) int {
+	var statusErr *safefetch.StatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, safefetch.ErrBlockedAddress), errors.Is(err, safefetch.ErrBlockedHost), errors.Is(err, safefetch.ErrScheme):
+		return http.StatusForbidden
+	case errors.Is(err, safefetch.ErrTooLarge), errors.Is(err, image.ErrTooManyPixels):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errNotAnImage):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &statusErr), errors.Is(err, safefetch.ErrTooManyHops):
+		return http.StatusBadGateway
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	case errors.As(err, &netErr):
+		return http.StatusBadGateway
 	}
+	// anything else is an image we could not decode
+	return http.StatusUnprocessableEntity
+}
 
-	buffer := bytes.NewBuffer([]byte{})
-	buffer.ReadFrom(resp.Body)
+// serveImageError answers with a placeholder image, or an error card when
+// the phone navigated to the image URL itself
+func (s *imageService) serveImageError(c echo.Context, status int, err error) error {
+	log.Println("image proxy:", c.QueryParam("url"), err)
 
-	if strings.Contains(resp.Header.Get("Accept"), "image/jpeg") {
-		return c.Blob(http.StatusOK, "image/jpeg", image.ImageToJPEG(buffer.Bytes(), 80))
+	accept := c.Request().Header.Get("Accept")
+	if strings.Contains(accept, "text/vnd.wap.wml") && !strings.Contains(accept, "image/") {
+		return serveErrorCard(c, s.renderer, status, "Sorry, this image could not be shown.")
 	}
 
-	return c.Blob(http.StatusOK, "image/vnd.wap.wbmp", image.ImageToWBMP(buffer.Bytes(), 80))
+	c.Response().Header().Del("ETag")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	return c.Blob(status, "image/vnd.wap.wbmp", image.Placeholder(16))
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/device"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/imagecache"
+	"github.com/labstack/echo/v4"
+)
+
+func TestServeImageNotModified(t *testing.T) {
+	cache, err := imagecache.New("", 1<<20, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &imageService{cache: cache}
+	e := echo.New()
+
+	const target = "/png-convert.wbmp?url=http%3A%2F%2Fexample.com%2Fa.png"
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		if err := s.serveImage(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// seed the cache with what the conversion would have stored, so nothing is fetched
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder())
+	opts, err := ditherOptions(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := imagecache.Key{URL: "http://example.com/a.png", Format: "wbmp", Size: int64(min(imageWidth, device.Default.ScreenWidth)), Dither: opts.String()}
+	entry, err := cache.Put(key, []byte("wbmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve("", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "wbmp" {
+		t.Fatalf("first request = %d %q, want 200 with the cached image", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != key.ETag() {
+		t.Errorf("ETag = %q, want %q", etag, key.ETag())
+	}
+	modified := rec.Header().Get("Last-Modified")
+	if modified != entry.Modified.UTC().Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %v", modified, entry.Modified)
+	}
+	if rec.Header().Get("Vary") != device.Vary {
+		t.Errorf("Vary = %q, want %q", rec.Header().Get("Vary"), device.Vary)
+	}
+
+	tests := []struct {
+		header, value string
+		status        int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"other"`, http.StatusOK},
+		{"If-Modified-Since", modified, http.StatusNotModified},
+		{"If-Modified-Since", entry.Modified.Add(time.Hour).UTC().Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", entry.Modified.Add(-time.Hour).UTC().Format(http.TimeFormat), http.StatusOK},
+		{"If-Modified-Since", "yesterday", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.header, tt.value)
+		if rec.Code != tt.status {
+			t.Errorf("%s: %s = %d, want %d", tt.header, tt.value, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("%s: %s sent a body with the 304", tt.header, tt.value)
+		}
+	}
+}
@@ -1,43 +1,118 @@
 package main
 
-import "math/rand"
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
-// NOTE: this is not at all scalable, this should become a database soon
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/linkcache"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/labstack/echo/v4"
+)
 
-// linkCache is a map of link IDs to their URLs
-// this is so we can query cache:linkID and fetch the full URL
+const (
+	linkTTL           = 7 * 24 * time.Hour
+	linkMaxEntries    = 100000
+	linkSweepInterval = time.Hour
+
+	// maxLinkLength is the longest link we hand out without going through the cache
+	maxLinkLength = 80
+)
+
+// the kinds of values in the link store, each kind is stored under its own
+// prefix so an ID handed out for a trip never resolves as a URL
+const (
+	linkURL     = "url"
+	linkTrip    = "trip"
+	linkJourney = "journey"
+	linkQuery   = "query"
+)
+
+// linkService hands out short IDs for links and keeps what they point to
 // this is done as the Nokia 7110 has a hard link length limit
-var linkCache = map[string]string{}
-var linkToID = map[string]string{}
+type linkService struct {
+	store    linkcache.LinkStore
+	renderer *render.Renderer
+}
 
-func generateID() string {
-	// generate a random 8 character string
-	randRunes := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	id := ""
-	for range 8 {
-		// pick random character
-		id += string(randRunes[rand.Intn(len(randRunes))])
+// newLinkService opens the configured link store, "memory" or "bolt"
+// which persists to cfg.Path. IDs are derived from cfg.Key, give every
+// replica the same key so they agree on the ID of a link
+func newLinkService(ctx context.Context, cfg config.Links, renderer *render.Renderer) (*linkService, error) {
+	if cfg.Key == "" {
+		log.Println("LINK_CACHE_KEY is not set, link IDs are guessable")
 	}
+	ids := linkcache.NewIDGenerator([]byte(cfg.Key))
+
+	var store linkcache.LinkStore
+	var err error
+	switch cfg.Store {
+	case "bolt":
+		store, err = linkcache.NewBoltStore(cfg.Path, ids, linkTTL)
+	default:
+		store = linkcache.NewMemoryStore(ids, linkTTL, linkMaxEntries, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	linkcache.StartSweeper(ctx, store, linkSweepInterval)
+	return &linkService{store: store, renderer: renderer}, nil
+}
 
+func (l *linkService) Close() error {
+	return l.store.Close()
+}
+
+// put stores a value of kind and returns its ID, or an empty string when that failed
+func (l *linkService) put(kind, value string) string {
+	id, err := l.store.Store(kind + ":" + value)
+	if err != nil {
+		log.Println("failed to store link:", err)
+		return ""
+	}
 	return id
 }
 
-func StoreLink(link string) string {
-	if _, exists := linkToID[link]; exists {
-		return linkToID[link]
+// get returns the value of kind stored under id, or an empty string
+func (l *linkService) get(kind, id string) string {
+	value, err := l.store.Get(id)
+	if err != nil {
+		log.Println("failed to get link:", err)
+		return ""
 	}
+	value, ok := strings.CutPrefix(value, kind+":")
+	if !ok {
+		return ""
+	}
+	return value
+}
 
-	id := generateID()
-	_, exists := linkCache[id]
-	for exists {
-		id = generateID()
-		_, exists = linkCache[id]
+// shortLink keeps href as is when it fits the phone, longer ones go through /l/
+func (l *linkService) shortLink(href string) string {
+	if len(href) <= maxLinkLength {
+		return href
 	}
-	linkCache[id] = link
-	linkToID[link] = id
-	return id
+	id := l.put(linkURL, href)
+	if id == "" {
+		return ""
+	}
+	return "/l/" + id
 }
 
-func GetLink(id string) string {
-	return linkCache[id]
+// serveLink redirects a /l/ link to where it points
+func (l *linkService) serveLink(c echo.Context) error {
+	link := l.get(linkURL, c.Param("id"))
+	if link == "" {
+		return serveErrorCard(c, l.renderer, http.StatusNotFound, "This link has expired.")
+	}
+	// never send the phone anywhere but the web
+	if u, err := url.Parse(link); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return serveErrorCard(c, l.renderer, http.StatusNotFound, "This link has expired.")
+	}
+	return c.Redirect(http.StatusFound, link)
 }
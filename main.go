@@ -1,71 +1,183 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
 	"github.com/labstack/echo/v4"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish on SIGTERM
+const shutdownTimeout = 15 * time.Second
+
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file, environment variables override it")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// templates are parsed up front, in dev mode they are reloaded when they change
+	renderer, err := render.New(cfg.Server.StaticDir, cfg.Server.DevMode)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	links, err := newLinkService(ctx, cfg.Links, renderer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer links.Close()
+
+	devices, err := newDeviceDetector(cfg.Devices)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	decks := newDeckSplitter(ctx, cfg.Links, renderer)
+
+	images, err := newImageService(ctx, cfg.Images, renderer, links)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	navigator, err := newNavigatorService(cfg.Navigator, renderer, links)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	news, err := newNewsService(cfg.News, renderer, links)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer news.Close()
+	news.start(ctx)
+	weather := newWeatherService(cfg.Weather, renderer)
+	barcodes := newBarcodeService(renderer)
+	files := &staticFiles{dir: cfg.Server.StaticDir, renderer: renderer}
+
+	ready := &readiness{services: map[string]statusReporter{
+		"news":      news,
+		"weather":   weather,
+		"navigator": navigator,
+	}}
+
 	e := echo.New()
-	e.GET("/", serveHome)
-	e.GET("/wap/*", serveWAP)
-	e.GET("/dl/*", serveDL)
+	e.HideBanner = true
+	e.GET("/ready", ready.serveReady)
+
+	wap := e.Group("", deviceMiddleware(devices), wmlMiddleware(validateDeck(cfg.Server.WMLStrict, renderer), decks.splitDeck, compileWML, transcodeDeck))
+	wap.GET("/", files.serveHome)
+	wap.GET("/wap/*", files.serveWAP)
+	wap.GET("/dl/*", files.serveDL)
+	wap.GET("/more", decks.serveMore)
+	wap.GET("/l/:id", links.serveLink)
+
+	wap.GET("/navigator/*", files.serveSection("navigator"))
+	wap.GET("/navigator/query", navigator.serveQuery)
+	wap.GET("/navigator/board", navigator.serveBoard)
+	wap.GET("/navigator/trip", navigator.serveTrip)
+	wap.GET("/navigator/conn", navigator.serveConn)
 
-	e.GET("/navigator/*", serveNavigator)
-	e.GET("/navigator/query", serveNavigatorQuery)
+	wap.GET("/nws/", news.serveIndex)
+	wap.GET("/nws/list", news.serveList)
+	wap.GET("/nws/item", news.serveItem)
+	wap.GET("/nws/read", news.serveRead)
+	wap.GET("/nws/categories", news.serveCategories)
+	wap.GET("/nws/search", news.serveSearch)
 
-	e.GET("/nws/list", serveNewsList)
-	e.GET("/nws/item", serveNewsItem)
+	wap.GET("/barcode/*", files.serveSection("barcode"))
+	wap.GET("/barcode/barcode", barcodes.servePage)
+	wap.GET("/barcode/image.wbmp", barcodes.serveImage)
+	wap.GET("/png-convert.wbmp", images.serveImage)
 
-	e.GET("/barcode/*", serveBarcode)
-	e.GET("/barcode/barcode", serveBarcodePage)
-	e.GET("/barcode/image.wbmp", serveBarcodeImage)
-	e.GET("/png-convert.wbmp", serveImage)
+	wap.GET("/weather/location", weather.serveLocation)
+	wap.GET("/weather/details", weather.serveDetails)
+	wap.GET("/weather/hourly", weather.serveHourly)
+	wap.GET("/weather/daily", weather.serveDaily)
 
-	e.GET("/weather/location", serveWeatherLocation)
-	e.GET("/weather/details", serveWeatherDetailView)
-	e.GET("/weather/hourly", serveWeatherHourly)
-	e.GET("/weather/daily", serveWeatherDaily)
+	go func() {
+		if err := e.Start(cfg.Server.Listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
 
-	e.Start(":8080")
+	<-ctx.Done()
+	// a second signal stops right away
+	stop()
+	ready.stopping.Store(true)
+
+	// keep serving until the load balancer saw /ready fail and stopped sending new requests
+	log.Println("shutting down, waiting", cfg.Server.DrainPeriod, "for the load balancer")
+	time.Sleep(cfg.Server.DrainPeriod)
+
+	log.Println("draining requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Println("shutdown:", err)
+	}
 }
 
-func serveHome(c echo.Context) error {
-	f, err := os.Open("./static/home.wml")
+// staticFiles serves the decks and images that are not templated
+type staticFiles struct {
+	dir string
+	// renderer knows which decks are templates, their sources are not served
+	renderer *render.Renderer
+}
+
+// templated reports whether file is a template that only its handler may serve
+func (s *staticFiles) templated(file string) bool {
+	return strings.HasSuffix(file, ".wml") && !s.renderer.Static(file)
+}
 
-	if err == os.ErrNotExist {
-		c.String(http.StatusNotFound, "")
+func (s *staticFiles) serveHome(c echo.Context) error {
+	c.Set("template", "home.wml")
+	f, err := os.Open(filepath.Join(s.dir, "home.wml"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return c.String(http.StatusNotFound, "")
 	} else if err != nil {
-		log.Panicln(err)
+		log.Println(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
 	return c.Stream(http.StatusOK, "text/vnd.wap.wml", f)
 }
 
-func serveDL(c echo.Context) error {
-	c.Path()
+func (s *staticFiles) serveDL(c echo.Context) error {
 	req := c.Request()
 	if req == nil {
 		return c.String(http.StatusInternalServerError, "")
 	}
-	r, err := os.OpenRoot("./static/dl")
+	r, err := os.OpenRoot(filepath.Join(s.dir, "dl"))
 	if err != nil {
-		log.Panicln(err)
+		log.Println(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
 	file := strings.TrimPrefix(req.URL.Path, "/dl/")
 	f, err := r.Open(file)
 
-	if err == os.ErrNotExist {
+	if errors.Is(err, os.ErrNotExist) {
 		return c.String(http.StatusNotFound, "")
 	} else if err != nil {
-		log.Panicln(err)
+		log.Println(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
@@ -79,32 +191,81 @@ func serveDL(c echo.Context) error {
 	return c.Stream(http.StatusOK, contentType, f)
 }
 
-func serveWAP(c echo.Context) error {
-	c.Path()
+func (s *staticFiles) serveWAP(c echo.Context) error {
 	req := c.Request()
 	if req == nil {
 		return c.String(http.StatusInternalServerError, "")
 	}
-	file := strings.TrimPrefix(req.URL.Path, "/wap/")
-	r, err := os.OpenRoot("./static/")
+	file := path.Clean(strings.TrimPrefix(req.URL.Path, "/wap/"))
+	if s.templated(file) {
+		// partials and decks filled in by a handler
+		return c.String(http.StatusNotFound, "")
+	}
+	r, err := os.OpenRoot(s.dir)
 	if err != nil {
-		log.Panicln(err)
+		log.Println(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
+	c.Set("template", file)
 	f, err := r.Open(file)
 
-	if err == os.ErrNotExist {
+	if errors.Is(err, os.ErrNotExist) {
 		return c.String(http.StatusNotFound, "")
 	} else if err != nil {
-		log.Panicln(err)
+		log.Println(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	mime := "text/vnd.wap.wml"
+	return c.Stream(http.StatusOK, staticMime(file), f)
+}
+
+// serveSection serves the static decks of a section like /navigator/,
+// the section root shows its index.wml
+func (s *staticFiles) serveSection(section string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, file := path.Split(c.Request().URL.Path)
+		if file == "" || file == section {
+			file = "index.wml"
+		}
+
+		if s.templated(section + "/" + file) {
+			return c.String(http.StatusNotFound, "")
+		}
+
+		c.Set("template", section+"/"+file)
+		f, err := os.Open(filepath.Join(s.dir, section, file))
+
+		if errors.Is(err, os.ErrNotExist) {
+			return c.String(http.StatusNotFound, "")
+		} else if err != nil {
+			log.Println(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+
+		return c.Stream(http.StatusOK, staticMime(file), f)
+	}
+}
+
+func staticMime(file string) string {
 	if strings.HasSuffix(file, ".wbmp") {
-		mime = "image/vnd.wap.wbmp"
+		return "image/vnd.wap.wbmp"
 	}
+	return "text/vnd.wap.wml"
+}
+
+// serveErrorCard answers with a WML card explaining what went wrong
+func serveErrorCard(c echo.Context, renderer *render.Renderer, status int, message string) error {
+	c.Set("template", render.ErrorTemplate)
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+	return c.Blob(status, render.ContentType, errorCard(renderer, message))
+}
 
-	return c.Stream(http.StatusOK, mime, f)
+// errorCard renders the error deck for message
+func errorCard(renderer *render.Renderer, message string) []byte {
+	deck, err := renderer.Execute(render.ErrorTemplate, struct{ Message string }{Message: message})
+	if err != nil {
+		log.Println("Error rendering error card:", err)
+	}
+	return deck
 }
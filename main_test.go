@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/labstack/echo/v4"
+)
+
+func TestServeStaticSkipsTemplates(t *testing.T) {
+	renderer, err := render.New("static", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &staticFiles{dir: "static", renderer: renderer}
+	e := echo.New()
+
+	tests := []struct {
+		handler echo.HandlerFunc
+		target  string
+		status  int
+	}{
+		{s.serveWAP, "/wap/portal.wml", http.StatusOK},
+		{s.serveWAP, "/wap/bevelgacom.wbmp", http.StatusOK},
+		{s.serveWAP, "/wap/error.wml", http.StatusNotFound},
+		{s.serveWAP, "/wap/nws/item.wml", http.StatusNotFound},
+		{s.serveWAP, "/wap/partials/back.wml", http.StatusNotFound},
+		{s.serveWAP, "/wap/nws/../nws/list.wml", http.StatusNotFound},
+		{s.serveSection("navigator"), "/navigator/", http.StatusOK},
+		{s.serveSection("navigator"), "/navigator/trip.wml", http.StatusNotFound},
+		{s.serveSection("barcode"), "/barcode/barcode.wml", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		if err := tt.handler(e.NewContext(httptest.NewRequest(http.MethodGet, tt.target, nil), rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.status {
+			t.Errorf("%s = %d, want %d", tt.target, rec.Code, tt.status)
+		}
+	}
+}
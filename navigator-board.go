@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/dbnav"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/text"
+	"github.com/labstack/echo/v4"
+)
+
+// boardResults is the number of trains on a departure or arrival board
+const boardResults = 10
+
+// BoardEntry is one train on a departure or arrival board
+type BoardEntry struct {
+	Time string
+	// Delay is the expected delay in minutes, e.g. "+5"
+	Delay string
+	Line  string
+	// Direction is where the train goes for departures and where it comes from for arrivals
+	Direction string
+	Platform  string
+	// Trip links to the stops of the train
+	Trip string
+	// PlatformChanged is set when the train does not use its planned platform
+	PlatformChanged bool
+	Cancelled       bool
+}
+
+type boardPage struct {
+	Arrivals bool
+	Value    string
+
+	Station     *Station
+	StationList []Station
+
+	Updated string
+	Entries []BoardEntry
+}
+
+// serveBoard shows the departures or arrivals of a station, the classic
+// HAFAS "Abfahrtstafel"
+//
+//	s: station id or name to search for
+//	type: dep or arr
+func (n *navigatorService) serveBoard(c echo.Context) error {
+	page := boardPage{
+		Arrivals: c.QueryParam("type") == "arr",
+		Value:    c.QueryParam("s"),
+	}
+
+	var ok bool
+	if page.Station, page.StationList, ok = n.findStation(page.Value); !ok {
+		return c.String(http.StatusBadRequest, "Invalid station")
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+
+	if page.Station == nil {
+		return n.renderer.Render(c, "navigator/bhftafel.wml", page)
+	}
+
+	now := time.Now().In(n.tz)
+	page.Updated = now.Format("15:04")
+
+	var trains []dbnav.Alternative
+	results := boardResults
+	if page.Arrivals {
+		resp, err := n.nav.GetStopsIdArrivals(c.Request().Context(), page.Station.Id, &dbnav.GetStopsIdArrivalsParams{
+			When:    &now,
+			Results: &results,
+		})
+		if err != nil {
+			log.Println(err)
+			return c.String(http.StatusInternalServerError, "Internal server error")
+		}
+		data, err := dbnav.ParseGetStopsIdArrivalsResponse(resp)
+		if err != nil {
+			log.Println(err)
+			return c.String(http.StatusInternalServerError, "Internal server error")
+		}
+		if data.JSON2XX == nil {
+			log.Println(string(data.Body))
+			return c.String(http.StatusInternalServerError, "Internal server error")
+		}
+		trains = data.JSON2XX.Arrivals
+	} else {
+		resp, err := n.nav.GetStopsIdDepartures(c.Request().Context(), page.Station.Id, &dbnav.GetStopsIdDeparturesParams{
+			When:    &now,
+			Results: &results,
+		})
+		if err != nil {
+			log.Println(err)
+			return c.String(http.StatusInternalServerError, "Internal server error")
+		}
+		data, err := dbnav.ParseGetStopsIdDeparturesResponse(resp)
+		if err != nil {
+			log.Println(err)
+			return c.String(http.StatusInternalServerError, "Internal server error")
+		}
+		if data.JSON2XX == nil {
+			log.Println(string(data.Body))
+			return c.String(http.StatusInternalServerError, "Internal server error")
+		}
+		trains = data.JSON2XX.Departures
+	}
+
+	for _, train := range trains {
+		page.Entries = append(page.Entries, n.boardEntry(train, page.Arrivals))
+	}
+
+	return n.renderer.Render(c, "navigator/bhftafel.wml", page)
+}
+
+func (n *navigatorService) boardEntry(train dbnav.Alternative, arrival bool) BoardEntry {
+	entry := BoardEntry{}
+
+	entry.Time = n.clock(train.PlannedWhen)
+	entry.Delay = delayMinutes(train.Delay)
+
+	if train.Line != nil && train.Line.Name != nil {
+		entry.Line = *train.Line.Name
+	}
+
+	// arrivals carry the origin of the train in provenance
+	if arrival && train.Provenance != nil {
+		entry.Direction = *train.Provenance
+	} else if !arrival && train.Direction != nil {
+		entry.Direction = *train.Direction
+	}
+	entry.Direction = text.Truncate(entry.Direction, stationNameLength)
+
+	if train.Platform != nil {
+		entry.Platform = *train.Platform
+		entry.PlatformChanged = train.PlannedPlatform != nil && *train.PlannedPlatform != *train.Platform
+	} else if train.PlannedPlatform != nil {
+		entry.Platform = *train.PlannedPlatform
+	}
+
+	if train.TripId != nil {
+		entry.Trip = n.tripLink(*train.TripId)
+	}
+
+	entry.Cancelled = train.Cancelled != nil && *train.Cancelled
+	return entry
+}
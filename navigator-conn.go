@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/dbnav"
+	"github.com/labstack/echo/v4"
+)
+
+// connLink points to the live state of a connection. Refresh tokens run to
+// hundreds of characters so they go through the link cache, which also
+// keeps the URL short enough to bookmark on a Nokia 7110.
+func (n *navigatorService) connLink(refreshToken string) string {
+	id := n.links.put(linkJourney, refreshToken)
+	if id == "" {
+		return ""
+	}
+	return "/navigator/conn?id=" + id
+}
+
+type connPage struct {
+	Connection Connection
+	Updated    string
+}
+
+// serveConn refreshes a connection with its latest delays and platforms
+//
+//	id: link cache id of the refresh token
+func (n *navigatorService) serveConn(c echo.Context) error {
+	refreshToken := n.links.get(linkJourney, c.QueryParam("id"))
+	if refreshToken == "" {
+		return serveErrorCard(c, n.renderer, http.StatusNotFound, "This connection has expired, please search again.")
+	}
+
+	resp, err := n.nav.GetJourneysRef(c.Request().Context(), refreshToken, &dbnav.GetJourneysRefParams{})
+	if err != nil {
+		log.Println(err)
+		return c.String(http.StatusInternalServerError, "Internal server error")
+	}
+	data, err := dbnav.ParseGetJourneysRefResponse(resp)
+	if err != nil {
+		log.Println(err)
+		return c.String(http.StatusInternalServerError, "Internal server error")
+	}
+	if data.JSON2XX == nil {
+		log.Println(string(data.Body))
+		return c.String(http.StatusInternalServerError, "Internal server error")
+	}
+
+	conn, err := n.connection(1, data.JSON2XX.Journey)
+	if err != nil {
+		log.Println(err)
+		return serveErrorCard(c, n.renderer, http.StatusNotFound, "This connection has expired, please search again.")
+	}
+	// the refreshed journey may not repeat its token, the page keeps its own URL
+	conn.Refresh = "/navigator/conn?id=" + c.QueryParam("id")
+
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+
+	return n.renderer.Render(c, "navigator/conn.wml", connPage{
+		Connection: conn,
+		Updated:    time.Now().In(n.tz).Format("15:04"),
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/dbnav"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/text"
+	"github.com/labstack/echo/v4"
+)
+
+// tripPageStops is the number of stops on one page of a trip, so the deck
+// stays within what older phones accept
+const tripPageStops = 8
+
+// TripStop is a stop of a trip
+type TripStop struct {
+	Name string
+
+	ArrivalTime  string
+	ArrivalDelay string
+	// DepartureTime is empty at the last stop, ArrivalTime at the first
+	DepartureTime  string
+	DepartureDelay string
+
+	Platform        string
+	PlatformChanged bool
+	Cancelled       bool
+}
+
+type tripPage struct {
+	ID        string
+	Line      string
+	Direction string
+
+	Stops     []TripStop
+	Page      int
+	Pages     int
+	PrevPage  int
+	NextPage  int
+	Cancelled bool
+}
+
+// tripLink points to the stops of a trip, db-rest trip ids are too long
+// for a phone so they go through the link cache
+func (n *navigatorService) tripLink(tripID string) string {
+	id := n.links.put(linkTrip, tripID)
+	if id == "" {
+		return ""
+	}
+	return "/navigator/trip?id=" + id
+}
+
+// serveTrip lists the stops of a trip
+//
+//	id: link cache id of the trip id
+//	p: page, starting at 0
+func (n *navigatorService) serveTrip(c echo.Context) error {
+	tripID := n.links.get(linkTrip, c.QueryParam("id"))
+	if tripID == "" {
+		return serveErrorCard(c, n.renderer, http.StatusNotFound, "This trip has expired, please search again.")
+	}
+
+	page := 0
+	if c.QueryParam("p") != "" {
+		var err error
+		page, err = strconv.Atoi(c.QueryParam("p"))
+		if err != nil || page < 0 {
+			return c.String(http.StatusBadRequest, "")
+		}
+	}
+
+	stopovers := true
+	resp, err := n.nav.GetTripsId(c.Request().Context(), tripID, &dbnav.GetTripsIdParams{Stopovers: &stopovers})
+	if err != nil {
+		log.Println(err)
+		return c.String(http.StatusInternalServerError, "Internal server error")
+	}
+	data, err := dbnav.ParseGetTripsIdResponse(resp)
+	if err != nil {
+		log.Println(err)
+		return c.String(http.StatusInternalServerError, "Internal server error")
+	}
+	if data.JSON2XX == nil {
+		log.Println(string(data.Body))
+		return c.String(http.StatusInternalServerError, "Internal server error")
+	}
+	trip := data.JSON2XX.Trip
+
+	pageData := tripPage{
+		ID:        c.QueryParam("id"),
+		Cancelled: trip.Cancelled != nil && *trip.Cancelled,
+	}
+	if trip.Line != nil && trip.Line.Name != nil {
+		pageData.Line = *trip.Line.Name
+	}
+	if trip.Direction != nil {
+		pageData.Direction = text.Truncate(*trip.Direction, stationNameLength)
+	}
+
+	var stops []TripStop
+	if trip.Stopovers != nil {
+		for _, stopover := range *trip.Stopovers {
+			// trains pass through these without stopping
+			if stopover.PassBy != nil && *stopover.PassBy {
+				continue
+			}
+			stops = append(stops, n.tripStop(stopover))
+		}
+	}
+
+	pageData.Pages = max((len(stops)+tripPageStops-1)/tripPageStops, 1)
+	page = min(page, pageData.Pages-1)
+	pageData.Stops = stops[min(page*tripPageStops, len(stops)):min((page+1)*tripPageStops, len(stops))]
+	pageData.Page = page + 1
+	pageData.PrevPage = page - 1
+	pageData.NextPage = page + 1
+
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+
+	return n.renderer.Render(c, "navigator/trip.wml", pageData)
+}
+
+func (n *navigatorService) tripStop(stopover dbnav.StopOver) TripStop {
+	stop := TripStop{
+		ArrivalTime:    n.clock(stopover.PlannedArrival),
+		ArrivalDelay:   delayMinutes(stopover.ArrivalDelay),
+		DepartureTime:  n.clock(stopover.PlannedDeparture),
+		DepartureDelay: delayMinutes(stopover.DepartureDelay),
+		Cancelled:      stopover.Cancelled != nil && *stopover.Cancelled,
+	}
+
+	if stopover.Stop != nil {
+		if station, err := stopover.Stop.AsStation(); err == nil && station.Name != nil {
+			stop.Name = text.Truncate(*station.Name, stationNameLength)
+		}
+	}
+
+	planned, actual := stopover.PlannedDeparturePlatform, stopover.DeparturePlatform
+	if stopover.PlannedDeparture == nil {
+		planned, actual = stopover.PlannedArrivalPlatform, stopover.ArrivalPlatform
+	}
+	if actual != nil {
+		stop.Platform = *actual
+		stop.PlatformChanged = planned != nil && *planned != *actual
+	} else if planned != nil {
+		stop.Platform = *planned
+	}
+
+	return stop
+}
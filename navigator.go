@@ -1,30 +1,51 @@
 package main
 
 import (
-	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
 	"slices"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
 	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/dbnav"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/text"
 	"github.com/labstack/echo/v4"
 	"github.com/lithammer/fuzzysearch/fuzzy"
 )
 
 const dbTime = "2006-01-02T15:04:05-07:00"
 
-var tz *time.Location
+// stationNameLength keeps station names on one line of a connection
+const stationNameLength = 24
 
-var nav *dbnav.Client
+// clock formats a db-rest timestamp as local time, e.g. 15:04
+func (n *navigatorService) clock(timestamp *string) string {
+	if timestamp == nil {
+		return ""
+	}
+	t, err := time.Parse(dbTime, *timestamp)
+	if err != nil {
+		return ""
+	}
+	return t.In(n.tz).Format("15:04")
+}
+
+// delayMinutes formats a db-rest delay, which is in seconds, e.g. +5
+func delayMinutes(delay *float32) string {
+	if delay == nil || *delay < 60 {
+		return ""
+	}
+	return fmt.Sprintf("+%d", int(*delay)/60)
+}
 
 type Station struct {
 	Id        string
@@ -37,11 +58,17 @@ type Leg struct {
 	To                string
 	DepartureTime     string
 	DeparturePlatform string
+	// DeparturePlatformChanged is set when the train leaves from another platform than planned
+	DeparturePlatformChanged bool
 
-	ArrivalTime     string
-	ArrivalPlatform string
+	ArrivalTime            string
+	ArrivalPlatform        string
+	ArrivalPlatformChanged bool
 
 	Line string
+	// Trip links to the stops of the leg
+	Trip      string
+	Cancelled bool
 }
 
 type Connection struct {
@@ -54,45 +81,155 @@ type Connection struct {
 	To   string
 
 	Legs []Leg
+
+	// Refresh links to the live state of the connection
+	Refresh string
 }
 
 type queryPage struct {
 	From  *Station
 	To    *Station
+	Via1  *Station
+	Via2  *Station
 	Modes string
 
 	FromValue string
 	ToValue   string
+	Via1Value string
+	Via2Value string
 
 	Date string
 	Time string
 
 	FromList []Station
 	ToList   []Station
+	Via1List []Station
+	Via2List []Station
+
+	// ViaDropped is set when both via stations were given, db-rest only takes one
+	ViaDropped bool
 
 	Connections []Connection
+	// Earlier and Later repeat the search for the connections before and after this page
+	Earlier string
+	Later   string
 }
 
-var Stations map[string]Station = make(map[string]Station)
+// queryResults is the number of connections on a results page
+const queryResults = 6
+
+// queryLink repeats the search of a results page with an earlierRef or
+// laterRef, these run to hundreds of characters so they go through the
+// link cache
+func (n *navigatorService) queryLink(p queryPage, via *string, param, ref string) string {
+	id := n.links.put(linkQuery, ref)
+	if id == "" {
+		return ""
+	}
+
+	query := url.Values{}
+	query.Set("s", p.From.Id)
+	query.Set("z", p.To.Id)
+	if via != nil {
+		query.Set("via1", *via)
+	}
+	if p.Modes != "" {
+		query.Set("p", p.Modes)
+	}
+	query.Set(param, id)
+	return "/navigator/query?" + query.Encode()
+}
 
-func init() {
-	apiURL := os.Getenv("NAVIGATOR_API_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:3000"
+// hafasProducts are the products in the order of the HAFAS wapProductsFilter
+// bitmask: ICE, IC/EC, IR/D, RE/RB, S-Bahn, Bus, Schiff, U-Bahn, STR, AST
+func hafasProducts(p *dbnav.ProfileSpecificProducts) []**bool {
+	return []**bool{
+		&p.NationalExpress, &p.National, &p.RegionalExpress, &p.Regional, &p.Suburban,
+		&p.Bus, &p.Ferry, &p.Subway, &p.Tram, &p.Taxi,
 	}
-	var err error
-	nav, err = dbnav.NewClient(apiURL)
+}
+
+// parseProducts reads the HAFAS wapProductsFilter, a 10 digit bitmask like
+// 1111101000 for trains only. A multiple select sends several masks
+// separated by ;, they are combined. An empty filter allows all products.
+func parseProducts(filter string) (*dbnav.ProfileSpecificProducts, error) {
+	if filter == "" {
+		return nil, nil
+	}
+
+	products := &dbnav.ProfileSpecificProducts{}
+	fields := hafasProducts(products)
+	mask := make([]bool, len(fields))
+	for _, value := range strings.Split(filter, ";") {
+		if len(value) != len(fields) || strings.Trim(value, "01") != "" {
+			return nil, fmt.Errorf("invalid products filter %q", value)
+		}
+		for i := range value {
+			mask[i] = mask[i] || value[i] == '1'
+		}
+	}
+
+	for i, field := range fields {
+		*field = &mask[i]
+	}
+	return products, nil
+}
+
+// navigatorService answers timetable queries through db-rest
+type navigatorService struct {
+	nav    *dbnav.Client
+	apiURL string
+	tz     *time.Location
+
+	stations    map[string]Station
+	stationsErr error
+
+	// links keeps trip ids and refresh tokens, they are too long for a phone
+	links    *linkService
+	renderer *render.Renderer
+}
+
+func newNavigatorService(cfg config.Navigator, renderer *render.Renderer, links *linkService) (*navigatorService, error) {
+	nav, err := dbnav.NewClient(cfg.APIURL)
 	if err != nil {
-		log.Panicln(err)
+		return nil, err
 	}
 
-	f, err := os.Open("./stations.csv")
+	tz, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
-		log.Panicln(err)
+		return nil, err
+	}
+
+	n := &navigatorService{nav: nav, tz: tz, apiURL: cfg.APIURL, links: links, renderer: renderer}
+
+	// without stations the rest of the site still works, searches just come up empty
+	n.stations, err = loadStations(cfg.StationsFile)
+	if err != nil {
+		log.Println("navigator has no stations:", err)
+		n.stationsErr = err
+		n.stations = map[string]Station{}
+	}
+
+	return n, nil
+}
+
+func (n *navigatorService) status() serviceStatus {
+	st := serviceStatus{Upstreams: []string{n.apiURL}}
+	if n.stationsErr != nil {
+		st.Error = n.stationsErr.Error()
+	}
+	return st
+}
+
+// loadStations reads the DB station list, a ; separated CSV with the DB id in column 22
+func loadStations(file string) (map[string]Station, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
 	}
 	defer f.Close()
-	// parse CSV
 
+	stations := map[string]Station{}
 	r := csv.NewReader(f)
 	r.Comma = ';'
 	for {
@@ -101,7 +238,7 @@ func init() {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		if len(record) < 22 {
@@ -111,25 +248,22 @@ func init() {
 		db_id := record[21]
 		name := record[1]
 
-		Stations[db_id] = Station{
+		stations[db_id] = Station{
 			Id:        db_id,
 			Name:      name,
 			NameLower: strings.ReplaceAll(strings.ToLower(name), "-", " "),
 		}
 	}
-
-	tz, err = time.LoadLocation("Europe/Berlin")
-	if err != nil {
-		log.Panicln(err)
-	}
+	return stations, nil
 }
 
-func seachStation(q string) []Station {
+// searchStation returns the stations best matching q, at most 10
+func (n *navigatorService) searchStation(q string) []Station {
 	result := []Station{}
 	match := map[string]int{}
 	q = strings.ToLower(q)
 	q = strings.ReplaceAll(q, "-", " ")
-	for _, s := range Stations {
+	for _, s := range n.stations {
 		if strings.EqualFold(q, s.NameLower) {
 			return []Station{s}
 		}
@@ -145,54 +279,150 @@ func seachStation(q string) []Station {
 		result = result[:10]
 	}
 
-	for _, s := range result {
-		log.Println(s.NameLower, match[s.Id])
-	}
-
 	return result
 }
 
-func serveNavigator(c echo.Context) error {
-	p := c.Request().URL.Path
+// findStation resolves a station parameter, either a DB id or a name to
+// search for. A search with one result picks it, more give a list to choose
+// from. ok is false for an id we do not know.
+func (n *navigatorService) findStation(value string) (station *Station, candidates []Station, ok bool) {
+	if value == "" {
+		return nil, nil, true
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+		// we got a search
+		res := n.searchStation(value)
+		if len(res) == 1 { // if we only have one result, we can skip the search page
+			return &res[0], nil, true
+		}
+		return nil, res, true
+	}
+	s, ok := n.stations[value]
+	if !ok {
+		return nil, nil, false
+	}
+	return &s, nil, true
+}
 
-	_, file := path.Split(p)
-	if file == "" || file == "navigator" {
-		file = "index.wml"
+// connection converts a db-rest journey for the connection cards
+func (n *navigatorService) connection(id int, journey dbnav.Journey) (Connection, error) {
+	conn := Connection{
+		Id: id,
 	}
+	if journey.Legs == nil || len(*journey.Legs) == 0 {
+		return conn, errors.New("journey without legs")
+	}
+	legs := *journey.Legs
 
-	f, err := os.Open("./static/navigator/" + file)
+	conn.DepartureTime = n.clock(legs[0].PlannedDeparture)
+	conn.ArrivalTime = n.clock(legs[len(legs)-1].PlannedArrival)
+	if conn.DepartureTime == "" || conn.ArrivalTime == "" {
+		return conn, errors.New("journey without planned times")
+	}
 
-	if err == os.ErrNotExist {
-		return c.String(http.StatusNotFound, "")
-	} else if err != nil {
-		log.Panicln(err)
-		return c.String(http.StatusInternalServerError, "")
+	if delay := delayMinutes(legs[0].DepartureDelay); delay != "" {
+		conn.DepartureTime += " " + delay
+	}
+	if delay := delayMinutes(legs[len(legs)-1].ArrivalDelay); delay != "" {
+		conn.ArrivalTime += " " + delay
+	}
+
+	if journey.RefreshToken != nil {
+		conn.Refresh = n.connLink(*journey.RefreshToken)
 	}
 
-	mime := "text/vnd.wap.wml"
-	if strings.HasSuffix(file, ".wbmp") {
-		mime = "image/vnd.wap.wbmp"
+	if legs[0].Origin != nil {
+		conn.From = placeName(legs[0].Origin.AsLocation())
+	}
+	if legs[len(legs)-1].Destination != nil {
+		conn.To = placeName(legs[len(legs)-1].Destination.AsLocation())
 	}
 
-	return c.Stream(http.StatusOK, mime, f)
+	for _, leg := range legs {
+		if leg.Walking != nil && *leg.Walking {
+			continue
+		}
+
+		newleg := Leg{
+			DepartureTime: n.clock(leg.PlannedDeparture),
+			ArrivalTime:   n.clock(leg.PlannedArrival),
+			Cancelled:     leg.Cancelled != nil && *leg.Cancelled,
+		}
+		if leg.Origin != nil {
+			newleg.From = placeName(leg.Origin.AsLocation())
+		}
+		if leg.Destination != nil {
+			newleg.To = placeName(leg.Destination.AsLocation())
+		}
+
+		if delay := delayMinutes(leg.DepartureDelay); delay != "" {
+			newleg.DepartureTime += " " + delay
+		}
+
+		if delay := delayMinutes(leg.ArrivalDelay); delay != "" {
+			newleg.ArrivalTime += " " + delay
+		}
+
+		if leg.DeparturePlatform != nil {
+			newleg.DeparturePlatform = *leg.DeparturePlatform
+			newleg.DeparturePlatformChanged = leg.PlannedDeparturePlatform != nil && *leg.PlannedDeparturePlatform != *leg.DeparturePlatform
+		}
+
+		if leg.ArrivalPlatform != nil {
+			newleg.ArrivalPlatform = *leg.ArrivalPlatform
+			newleg.ArrivalPlatformChanged = leg.PlannedArrivalPlatform != nil && *leg.PlannedArrivalPlatform != *leg.ArrivalPlatform
+		}
+
+		if leg.Line != nil && leg.Line.Name != nil {
+			newleg.Line = *leg.Line.Name
+		}
+
+		if leg.TripId != nil {
+			newleg.Trip = n.tripLink(*leg.TripId)
+		}
+
+		conn.Legs = append(conn.Legs, newleg)
+	}
+
+	conn.Changes = len(legs) - 1
+	return conn, nil
+}
+
+// placeName names where a leg starts or ends, stations and POIs have a
+// name but addresses only have an address
+func placeName(location dbnav.Location, err error) string {
+	switch {
+	case err != nil:
+		return ""
+	case location.Name != nil:
+		return text.Truncate(*location.Name, stationNameLength)
+	case location.Address != nil:
+		return text.Truncate(*location.Address, stationNameLength)
+	}
+	return ""
 }
 
-func serveNavigatorQuery(c echo.Context) error {
-	advanced := c.QueryParam("q")
-	var tmpl *template.Template
+func (n *navigatorService) serveQuery(c echo.Context) error {
+	advanced := c.QueryParam("advanced")
+	name := "navigator/query.wml"
 	if advanced == "true" {
-		tmpl = template.Must(template.ParseFiles("./static/navigator/query-advanced.wml"))
-	} else {
-		tmpl = template.Must(template.ParseFiles("./static/navigator/query.wml"))
+		name = "navigator/query-advanced.wml"
 	}
 
 	from := c.QueryParam("s") // these are the original HAFAS WAP query parameters
 	to := c.QueryParam("z")
+	via1 := c.QueryParam("via1")
+	via2 := c.QueryParam("via2")
 
-	dateStr := c.QueryParam("datum")
-	timeStr := c.QueryParam("zeit")
+	dateStr := c.QueryParam("d")
+	timeStr := c.QueryParam("t")
 
-	now := time.Now().In(tz)
+	products, err := parseProducts(c.QueryParam("p"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid products filter")
+	}
+
+	now := time.Now().In(n.tz)
 	if dateStr == "" {
 		// set to DDMMYY
 		dateStr = now.Format("020106")
@@ -206,59 +436,74 @@ func serveNavigatorQuery(c echo.Context) error {
 	pageData := queryPage{
 		FromValue: from,
 		ToValue:   to,
+		Via1Value: via1,
+		Via2Value: via2,
+		Modes:     c.QueryParam("p"),
 
 		Date: dateStr,
 		Time: timeStr,
 	}
 
-	if from != "" {
-		if _, err := strconv.ParseInt(from, 10, 64); err != nil {
-			// we got a search
-			res := seachStation(from)
-			if len(res) == 1 { // if we only have one result, we can skip the search page
-				pageData.From = &res[0]
-			} else if len(res) > 0 {
-				pageData.FromList = res
-			}
-		} else {
-			s, ok := Stations[from]
-			if !ok {
-				return c.String(http.StatusBadRequest, "Invalid from station")
-			}
-			pageData.From = &s
-		}
+	var ok bool
+	if pageData.From, pageData.FromList, ok = n.findStation(from); !ok {
+		return c.String(http.StatusBadRequest, "Invalid from station")
+	}
+	if pageData.To, pageData.ToList, ok = n.findStation(to); !ok {
+		return c.String(http.StatusBadRequest, "Invalid to station")
+	}
+	if pageData.Via1, pageData.Via1List, ok = n.findStation(via1); !ok {
+		return c.String(http.StatusBadRequest, "Invalid via station")
+	}
+	if pageData.Via2, pageData.Via2List, ok = n.findStation(via2); !ok {
+		return c.String(http.StatusBadRequest, "Invalid via station")
 	}
 
-	if to != "" {
-		if _, err := strconv.ParseInt(to, 10, 64); err != nil {
-			// we got a search
-			res := seachStation(to)
-			if len(res) == 1 { // if we only have one result, we can skip the search page
-				pageData.To = &res[0]
-			} else if len(res) > 0 {
-				pageData.ToList = res
-			}
-		} else {
-			s, ok := Stations[to]
-			if !ok {
-				return c.String(http.StatusBadRequest, "Invalid to station")
-			}
-			pageData.To = &s
+	// a via station that still has to be picked from a list keeps us on the query page
+	viasResolved := (via1 == "" || pageData.Via1 != nil) && (via2 == "" || pageData.Via2 != nil)
+
+	if pageData.From != nil && pageData.To != nil && viasResolved {
+		var via *string
+		switch {
+		case pageData.Via1 != nil:
+			via = &pageData.Via1.Id
+			pageData.ViaDropped = pageData.Via2 != nil
+		case pageData.Via2 != nil:
+			via = &pageData.Via2.Id
 		}
-	}
 
-	if pageData.From != nil && pageData.To != nil {
-		// parse date and time into one time.Time
-		date, err := time.ParseInLocation("0201061504", pageData.Date+pageData.Time, tz)
-		if err != nil {
-			return c.String(http.StatusBadRequest, "Invalid date or time")
+		results := queryResults
+		params := &dbnav.GetJourneysParams{
+			From:     &pageData.From.Id,
+			To:       &pageData.To.Id,
+			Via:      via,
+			Products: products,
+			Results:  &results,
 		}
 
-		resp, err := nav.GetJourneys(context.Background(), &dbnav.GetJourneysParams{
-			From:      &pageData.From.Id,
-			To:        &pageData.To.Id,
-			Departure: &date,
-		})
+		// earlier and later pages continue from a previous search instead of a departure time
+		switch {
+		case c.QueryParam("earlier") != "":
+			ref := n.links.get(linkQuery, c.QueryParam("earlier"))
+			if ref == "" {
+				return serveErrorCard(c, n.renderer, http.StatusNotFound, "These connections have expired, please search again.")
+			}
+			params.EarlierThan = &ref
+		case c.QueryParam("later") != "":
+			ref := n.links.get(linkQuery, c.QueryParam("later"))
+			if ref == "" {
+				return serveErrorCard(c, n.renderer, http.StatusNotFound, "These connections have expired, please search again.")
+			}
+			params.LaterThan = &ref
+		default:
+			// parse date and time into one time.Time
+			date, err := time.ParseInLocation("0201061504", pageData.Date+pageData.Time, n.tz)
+			if err != nil {
+				return c.String(http.StatusBadRequest, "Invalid date or time")
+			}
+			params.Departure = &date
+		}
+
+		resp, err := n.nav.GetJourneys(c.Request().Context(), params)
 		if err != nil {
 			log.Println(err)
 			return c.String(http.StatusInternalServerError, "Internal server error")
@@ -274,107 +519,28 @@ func serveNavigatorQuery(c echo.Context) error {
 		}
 
 		for i, journey := range data.JSON2XX.Journeys {
-			if i > 5 {
+			if i >= queryResults {
 				break
 			}
-			if journey.Legs == nil {
-				continue
-			}
-			legs := *journey.Legs
-
-			conn := Connection{
-				Id: i + 1,
-			}
-
-			plannedDeparture, err := time.Parse(dbTime, *legs[0].PlannedDeparture)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			plannedArrival, err := time.Parse(dbTime, *legs[len(legs)-1].PlannedArrival)
+			conn, err := n.connection(i+1, journey)
 			if err != nil {
 				log.Println(err)
 				continue
 			}
-
-			conn.DepartureTime = plannedDeparture.In(tz).Format("15:04")
-			conn.ArrivalTime = plannedArrival.In(tz).Format("15:04")
-
-			if legs[0].DepartureDelay != nil && *legs[0].DepartureDelay != 0 {
-				conn.DepartureTime += fmt.Sprintf(" +%d", int(*legs[0].DepartureDelay))
-			}
-			if legs[len(legs)-1].ArrivalDelay != nil && *legs[len(legs)-1].ArrivalDelay != 0 {
-				conn.ArrivalTime += fmt.Sprintf(" +%d", int(*legs[len(legs)-1].ArrivalDelay))
-			}
-
-			for legNum, leg := range legs {
-				if leg.Walking != nil && *leg.Walking {
-					continue
-				}
-				var origin, dest dbnav.Station
-				if leg.Origin != nil {
-					origin, _ = leg.Origin.AsStation()
-				}
-				if leg.Destination != nil {
-					dest, _ = leg.Destination.AsStation()
-				}
-
-				if legNum == 0 {
-					conn.From = *origin.Name
-				}
-				if legNum == len(legs)-1 {
-					conn.To = *dest.Name
-				}
-
-				newleg := Leg{
-					From: *origin.Name,
-					To:   *dest.Name,
-				}
-
-				if leg.DepartureDelay != nil && *leg.DepartureDelay != 0 {
-					newleg.DepartureTime += fmt.Sprintf(" +%d", int(*leg.DepartureDelay))
-				}
-
-				if leg.ArrivalDelay != nil && *leg.ArrivalDelay != 0 {
-					newleg.ArrivalTime += fmt.Sprintf(" +%d", int(*leg.ArrivalDelay))
-				}
-
-				if leg.DeparturePlatform != nil {
-					newleg.DeparturePlatform = *leg.DeparturePlatform
-				}
-
-				if leg.ArrivalPlatform != nil {
-					newleg.ArrivalPlatform = *leg.ArrivalPlatform
-				}
-
-				if leg.PlannedDeparture != nil {
-					depTime, _ := time.Parse(dbTime, *leg.PlannedDeparture)
-					newleg.DepartureTime = depTime.In(tz).Format("15:04")
-				}
-
-				if leg.PlannedArrival != nil {
-					arrTime, _ := time.Parse(dbTime, *leg.PlannedArrival)
-					newleg.ArrivalTime = arrTime.In(tz).Format("15:04")
-				}
-
-				if leg.Line != nil {
-					newleg.Line = *leg.Line.Name
-				}
-
-				conn.Legs = append(conn.Legs, newleg)
-			}
-
-			conn.Changes = len(legs) - 1
-
 			pageData.Connections = append(pageData.Connections, conn)
 		}
 
-		tmpl = template.Must(template.ParseFiles("./static/navigator/list.wml"))
+		if ref := data.JSON2XX.EarlierRef; ref != nil {
+			pageData.Earlier = n.queryLink(pageData, via, "earlier", *ref)
+		}
+		if ref := data.JSON2XX.LaterRef; ref != nil {
+			pageData.Later = n.queryLink(pageData, via, "later", *ref)
+		}
+
 		// we have everything we need for a results page
-		c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
 		c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
 
-		err = tmpl.Execute(c.Response().Writer, pageData)
+		err = n.renderer.Render(c, "navigator/list.wml", pageData)
 		if err != nil {
 			log.Println(err)
 			return c.String(http.StatusInternalServerError, "Internal server error")
@@ -382,8 +548,7 @@ func serveNavigatorQuery(c echo.Context) error {
 		return nil
 	}
 
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
 	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
 
-	return tmpl.Execute(c.Response().Writer, pageData)
+	return n.renderer.Render(c, name, pageData)
 }
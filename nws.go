@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,41 +10,134 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"text/template"
-
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/htmlwml"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/news"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/safefetch"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/text"
 	"github.com/labstack/echo/v4"
-	"github.com/mmcdole/gofeed"
 )
 
 type nwsItem struct {
-	Title    string
-	Href     string
-	Content  string
-	ImageURL string
+	Title string
+	Href  string
+}
+
+// readerTimeout bounds the W@PFind lookup, the phone waits for it
+const readerTimeout = 5 * time.Second
+
+// newsService serves the news section from the articles the poller stored
+type newsService struct {
+	feeds        *news.Aggregator
+	store        *news.Store
+	readerURL    string
+	pollInterval time.Duration
+	// reader asks W@PFind where the reader version of an article lives,
+	// it answers with a redirect we do not follow
+	reader *http.Client
+
+	// links shortens article links and images for the phone
+	links    *linkService
+	renderer *render.Renderer
+}
+
+func newNewsService(cfg config.News, renderer *render.Renderer, links *linkService) (*newsService, error) {
+	var feeds []*news.Feed
+	for _, f := range cfg.Feeds {
+		feed, err := news.NewFeed(f.ID, f.Title, f.URL, f.Language, f.SkipTitles)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	store, err := news.OpenStore(cfg.StorePath, cfg.Retention)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newsService{
+		feeds:        news.NewAggregator(feeds, store, safefetch.New(safefetch.DefaultOptions)),
+		store:        store,
+		readerURL:    cfg.ReaderURL,
+		pollInterval: cfg.PollInterval,
+		reader: &http.Client{
+			Timeout: readerTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		links:    links,
+		renderer: renderer,
+	}, nil
+}
+
+// start polls the feeds in the background until ctx is done
+func (s *newsService) start(ctx context.Context) {
+	go s.feeds.Run(ctx, s.pollInterval)
+}
+
+func (s *newsService) Close() error {
+	return s.store.Close()
+}
+
+func (s *newsService) status() serviceStatus {
+	st := serviceStatus{}
+	var failed []string
+	for _, f := range s.feeds.Feeds() {
+		st.Upstreams = append(st.Upstreams, f.URL)
+		if err := s.feeds.Err(f); err != nil {
+			failed = append(failed, f.ID+": "+err.Error())
+		}
+	}
+	st.Upstreams = append(st.Upstreams, s.readerURL)
+	st.Error = strings.Join(failed, "; ")
+	return st
 }
 
-func grabFeed() (*gofeed.Feed, error) {
-	fp := gofeed.NewParser()
-	return fp.ParseURL("https://www.vrt.be/vrtnws/nl.rss.articles.xml")
+type feedIndexPage struct {
+	Feeds []*news.Feed
+}
+
+// serveIndex lists the feeds
+func (s *newsService) serveIndex(c echo.Context) error {
+	return s.renderer.Render(c, "nws/index.wml", feedIndexPage{Feeds: s.feeds.Feeds()})
 }
 
 type listPage struct {
+	Feed      *news.Feed
+	Category  string
 	MaxItems  int
 	NewOffset int
 	Items     []nwsItem
 	ShowMore  bool
 }
 
-func serveNewsList(c echo.Context) error {
-	feed, err := grabFeed()
+func (s *newsService) serveList(c echo.Context) error {
+	// pages without a feed parameter show the first feed
+	feed, err := s.feeds.Feed(c.QueryParam("feed"))
+	if err != nil {
+		return serveErrorCard(c, s.renderer, http.StatusNotFound, "This news feed does not exist.")
+	}
+	category := c.QueryParam("cat")
+	var articles []news.Article
+	if category != "" {
+		articles, err = s.feeds.CategoryArticles(feed, category)
+	} else {
+		articles, err = s.feeds.Articles(feed)
+	}
 	if err != nil {
+		log.Println("Error reading feed", feed.ID, err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
 	maxItems := 30
 	if c.QueryParam("max") != "" {
 		maxItems, err = strconv.Atoi(c.QueryParam("max"))
-		if err != nil {
+		if err != nil || maxItems < 0 {
 			return c.String(http.StatusBadRequest, "")
 		}
 	}
@@ -50,31 +145,32 @@ func serveNewsList(c echo.Context) error {
 	var offset int64 = 0
 	if c.QueryParam("o") != "" {
 		offset, err = strconv.ParseInt(c.QueryParam("o"), 10, 64)
-		if err != nil {
-			log.Println(err)
+		if err != nil || offset < 0 {
 			return c.String(http.StatusBadRequest, "")
 		}
 	}
 
-	tmpl := template.Must(template.ParseFiles("./static/nws/list.wml"))
-
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
 	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
 
+	nwsItems, showMore := pageItems(articles, int(offset), maxItems)
+
+	return s.renderer.Render(c, "nws/list.wml", listPage{Feed: feed, Category: category, Items: nwsItems, MaxItems: maxItems, NewOffset: int(offset) + maxItems, ShowMore: showMore})
+}
+
+// pageItems turns the articles from offset on into at most maxItems list entries
+func pageItems(articles []news.Article, offset, maxItems int) ([]nwsItem, bool) {
+	offset, maxItems = max(offset, 0), max(maxItems, 0)
+
 	nwsItems := []nwsItem{}
-	for _, item := range feed.Items {
-		if strings.HasPrefix(item.Title, "Het weer") || strings.HasPrefix(item.Title, "Het Journaal") {
-			// useless news items
-			continue
-		}
+	for _, article := range articles {
 		nwsItems = append(nwsItems, nwsItem{
-			Title: fixHTML(trimTitle(item.Title)),
-			Href:  fmt.Sprintf("/nws/item?id=%s", template.URLQueryEscaper(item.GUID)),
+			Title: trimTitle(article.Title),
+			Href:  fmt.Sprintf("/nws/item?feed=%s&id=%s", article.Feed, url.QueryEscape(article.GUID)),
 		})
 	}
 
-	if int(offset) > len(nwsItems) {
-		offset = int64(len(nwsItems))
+	if offset > len(nwsItems) {
+		offset = len(nwsItems)
 	}
 
 	if offset > 0 {
@@ -90,113 +186,260 @@ func serveNewsList(c echo.Context) error {
 		showMore = false
 	}
 
-	return tmpl.Execute(c.Response().Writer, listPage{Items: nwsItems, MaxItems: maxItems, NewOffset: int(offset) + maxItems, ShowMore: showMore})
+	return nwsItems, showMore
 }
 
-func serveNewsItem(c echo.Context) error {
-	feed, err := grabFeed()
+// serveCategories lists the categories of the stored articles of a feed
+func (s *newsService) serveCategories(c echo.Context) error {
+	feed, err := s.feeds.Feed(c.QueryParam("feed"))
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "")
+		return serveErrorCard(c, s.renderer, http.StatusNotFound, "This news feed does not exist.")
 	}
 
-	tmpl := template.Must(template.ParseFiles("./static/nws/item.wml"))
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+
+	return s.renderer.Render(c, "nws/categories.wml", struct {
+		Feed       *news.Feed
+		Categories []news.Category
+	}{Feed: feed, Categories: s.feeds.Categories(feed)})
+}
+
+const searchResults = 10
+
+type searchPage struct {
+	Query     string
+	Searched  bool
+	Items     []nwsItem
+	ShowMore  bool
+	NewOffset int
+}
+
+// serveSearch searches the titles and descriptions of all feeds
+func (s *newsService) serveSearch(c echo.Context) error {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	page := searchPage{Query: query}
+	if query == "" {
+		return s.renderer.Render(c, "nws/search.wml", page)
+	}
 
 	offset := 0
 	if c.QueryParam("o") != "" {
+		var err error
 		offset, err = strconv.Atoi(c.QueryParam("o"))
-		if err != nil {
+		if err != nil || offset < 0 {
 			return c.String(http.StatusBadRequest, "")
 		}
 	}
 
-	id := c.QueryParam("id")
-	var article *gofeed.Item
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+
+	page.Searched = true
+	page.Items, page.ShowMore = pageItems(s.feeds.Search(query), offset, searchResults)
+	page.NewOffset = offset + searchResults
+	return s.renderer.Render(c, "nws/search.wml", page)
+}
+
+// articlePage is the number of characters on one page of an article, images
+// count as articleImage characters
+const (
+	articlePage  = 700
+	articleImage = 100
+)
 
-	for _, item := range feed.Items {
-		if item.GUID == id {
-			article = item
+// nwsParagraph is a paragraph of an article ready for the template, Image
+// is already routed through the image converter
+type nwsParagraph struct {
+	Text    string
+	Heading bool
+	Image   string
+	// Markup is WML converted from the HTML in a feed, it is not escaped again
+	Markup string
+}
+
+func (s *newsService) serveItem(c echo.Context) error {
+	// pages without a feed parameter show the first feed
+	feed, err := s.feeds.Feed(c.QueryParam("feed"))
+	if err != nil {
+		return serveErrorCard(c, s.renderer, http.StatusNotFound, "This news feed does not exist.")
+	}
+	page := 0
+	if c.QueryParam("p") != "" {
+		page, err = strconv.Atoi(c.QueryParam("p"))
+		if err != nil || page < 0 {
+			return c.String(http.StatusBadRequest, "")
 		}
 	}
 
-	if article == nil {
-		log.Println(id)
-		return c.String(http.StatusNotFound, "")
+	id := c.QueryParam("id")
+	article, err := s.feeds.Article(feed, id)
+	if errors.Is(err, news.ErrNotFound) {
+		return serveErrorCard(c, s.renderer, http.StatusNotFound, "This article is no longer available.")
+	} else if err != nil {
+		log.Println("Error reading article", feed.ID, id, err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	// without a body we fall back to the feed description and W@PFind
+	var description []nwsParagraph
+	fullRead := ""
+	body, err := s.feeds.Body(feed, article)
+	if err != nil {
+		log.Println("Error reading article body", article.Link, err)
+	}
+	if len(body) == 0 {
+		description = s.describe(article)
+		// W@PFind is only asked when the reader picks it, not on every view
+		fullRead = fmt.Sprintf("/nws/read?feed=%s&id=%s", url.QueryEscape(feed.ID), url.QueryEscape(id))
+	} else if body[0].Heading && body[0].Text == article.Title {
+		// the page repeats the headline we already show
+		body = body[1:]
 	}
 
-	content := fmt.Sprintf("%s<br />%s", article.Title, article.Description)
-	fullRead := getWAPFindReaderURL(article.Link)
+	pages := paginate(body, articlePage)
+	if page >= len(pages) {
+		page = len(pages) - 1
+	}
 
-	item := nwsItem{
-		Title:   fixHTML(trimTitle(article.Title)),
-		Content: fixHTML(content),
+	paragraphs := []nwsParagraph{}
+	if page == 0 {
+		paragraphs = append(paragraphs, nwsParagraph{Text: article.Title, Heading: true})
+		if article.ImageURL != "" {
+			paragraphs = append(paragraphs, nwsParagraph{Image: s.proxiedImage(article.ImageURL)})
+		}
+		paragraphs = append(paragraphs, description...)
+	}
+	for _, b := range pages[page] {
+		if b.Image != "" {
+			paragraphs = append(paragraphs, nwsParagraph{Image: s.proxiedImage(b.Image)})
+			continue
+		}
+		paragraphs = append(paragraphs, nwsParagraph{Text: b.Text, Heading: b.Heading})
 	}
 
-	if len(content) > offset {
-		item.Content = content[offset:]
+	return s.renderer.Render(c, "nws/item.wml", struct {
+		Feed       *news.Feed
+		Title      string
+		Paragraphs []nwsParagraph
+		ShowMore   bool
+		NextPage   int
+		Page       int
+		Pages      int
+		ID         string
+		FullRead   string
+	}{
+		Feed:       feed,
+		Title:      trimTitle(article.Title),
+		Paragraphs: paragraphs,
+		ShowMore:   page+1 < len(pages),
+		NextPage:   page + 1,
+		Page:       page + 1,
+		Pages:      len(pages),
+		ID:         id,
+		FullRead:   fullRead,
+	})
+}
+
+// describe converts the HTML description of an article from its feed
+func (s *newsService) describe(article news.Article) []nwsParagraph {
+	markup, err := htmlwml.Convert(article.Description, htmlwml.Options{
+		Base:  article.Link,
+		Image: s.proxiedImage,
+		Link:  s.links.shortLink,
+	})
+	if err != nil {
+		log.Println("Error converting description", article.Link, err)
+		return []nwsParagraph{{Text: article.Description}}
 	}
 
-	showMore := false
-	if len(content) > 700 {
-		item.Content = fmt.Sprintf("%s...", content[:700])
-		showMore = true
+	paragraphs := []nwsParagraph{}
+	for _, m := range markup {
+		paragraphs = append(paragraphs, nwsParagraph{Markup: m})
 	}
+	return paragraphs
+}
 
-	if article.Image != nil {
-		item.ImageURL = article.Image.URL
+// proxiedImage points an article image at the WBMP converter, through the
+// link cache so the URL fits the phone
+func (s *newsService) proxiedImage(src string) string {
+	return fmt.Sprintf("/png-convert.wbmp?url=%s", url.QueryEscape("cache:"+s.links.put(linkURL, src)))
+}
 
-	} else if len(article.Enclosures) > 0 {
-		for _, enclosure := range article.Enclosures {
-			if strings.HasPrefix(enclosure.Type, "image") {
-				item.ImageURL = enclosure.URL
-				break
+// paginate splits an article into pages of about size characters, breaking
+// between paragraphs and only inside one that does not fit a page on its own
+func paginate(blocks []news.Block, size int) [][]news.Block {
+	pages := [][]news.Block{}
+	var current []news.Block
+	used := 0
+	for _, b := range blocks {
+		for _, part := range splitBlock(b, size) {
+			n := text.Len(part.Text)
+			if part.Image != "" {
+				n = articleImage
 			}
+			if used > 0 && used+n > size {
+				pages = append(pages, current)
+				current, used = nil, 0
+			}
+			current = append(current, part)
+			used += n
 		}
 	}
+	return append(pages, current)
+}
 
-	if item.ImageURL != "" {
-		cachedLink := StoreLink(item.ImageURL)
-		item.ImageURL = fmt.Sprintf("/png-convert.wbmp?url=%s", url.QueryEscape("cache:"+cachedLink))
+// splitBlock cuts a paragraph longer than size between words
+func splitBlock(b news.Block, size int) []news.Block {
+	if b.Image != "" {
+		return []news.Block{b}
 	}
-
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-	err = tmpl.Execute(c.Response().Writer, struct {
-		Item      nwsItem
-		ShowMore  bool
-		NewOffset int
-		ID        string
-		FullRead  string
-	}{Item: item, ShowMore: showMore, NewOffset: offset + 700, ID: id, FullRead: fullRead})
-	if err != nil {
-		log.Println(err)
-		return err
+	var parts []news.Block
+	for _, page := range text.Paginate(b.Text, size) {
+		parts = append(parts, news.Block{Text: page, Heading: b.Heading})
 	}
-	return nil
+	return parts
 }
 
+// trimTitle keeps titles short enough for a phone's title bar and list
 func trimTitle(in string) string {
-	if len(in) > 40 {
-		return fmt.Sprintf("%s...", in[:40])
-	}
-
-	return in
+	return text.Truncate(in, 40)
 }
 
-func fixHTML(in string) string {
-	in = strings.ReplaceAll(in, "&", "&amp;")
-	in = strings.ReplaceAll(in, "<", "&lt;")
-	in = strings.ReplaceAll(in, ">", "&gt;")
-	return in
+// serveRead sends the phone to the W@PFind version of an article
+func (s *newsService) serveRead(c echo.Context) error {
+	feed, err := s.feeds.Feed(c.QueryParam("feed"))
+	if err != nil {
+		return serveErrorCard(c, s.renderer, http.StatusNotFound, "This news feed does not exist.")
+	}
+	article, err := s.feeds.Article(feed, c.QueryParam("id"))
+	if errors.Is(err, news.ErrNotFound) {
+		return serveErrorCard(c, s.renderer, http.StatusNotFound, "This article is no longer available.")
+	} else if err != nil {
+		log.Println("Error reading article", feed.ID, c.QueryParam("id"), err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	location := s.fullReadURL(c.Request().Context(), article.Link)
+	if location == "" {
+		return serveErrorCard(c, s.renderer, http.StatusBadGateway, "W@PFind could not open this article.")
+	}
+	return c.Redirect(http.StatusFound, location)
 }
 
-func getWAPFindReaderURL(urlStr string) string {
-	// call http://find.bevelgacom.be/l?u=$URL and return the location header
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+// fullReadURL asks W@PFind for the WML version of an article, it answers with a redirect
+func (s *newsService) fullReadURL(ctx context.Context, urlStr string) string {
+	base, err := url.Parse(s.readerURL)
+	if err != nil {
+		log.Println("Invalid WAP find reader URL:", err)
+		return ""
 	}
+	lookup := base.ResolveReference(&url.URL{Path: "l", RawQuery: "u=" + url.QueryEscape(urlStr)})
 
-	resp, err := client.Get("http://find.bevelgacom.be/l?u=" + url.QueryEscape(urlStr))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookup.String(), nil)
+	if err != nil {
+		log.Println("Invalid WAP find reader URL:", err)
+		return ""
+	}
+	resp, err := s.reader.Do(req)
 	if err != nil {
 		log.Println("Error fetching WAP find reader URL:", err)
 		return ""
@@ -208,14 +451,14 @@ func getWAPFindReaderURL(urlStr string) string {
 		body, _ := io.ReadAll(resp.Body)
 		log.Println("Response body:", string(body))
 		// log the url that was requested
-		log.Println("http://find.bevelgacom.be/l?u=" + url.QueryEscape(urlStr))
+		log.Println(lookup.String())
 		return ""
 	}
 
-	location := resp.Header.Get("Location")
-	if location == "" {
+	location, err := resp.Location()
+	if err != nil {
 		log.Println("No Location header found in response")
 		return ""
 	}
-	return location
+	return location.String()
 }
@@ -2,86 +2,83 @@ package barcode
 
 import (
 	"bytes"
-	"fmt"
-	"image/png"
-	"os"
+	"errors"
+	"image"
 
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wbmp"
 	"github.com/boombuler/barcode"
 	"github.com/boombuler/barcode/aztec"
 	"github.com/boombuler/barcode/code128"
 	"github.com/boombuler/barcode/qr"
-	"gopkg.in/gographics/imagick.v3/imagick"
+	"golang.org/x/image/draw"
 )
 
-func CreateQR(input string, size int64) []byte {
-	qrCode, _ := qr.Encode(input, qr.M, qr.Auto)
-	qrCode, _ = barcode.Scale(qrCode, int(size), int(size))
+// ErrSize is returned for a size that is not a positive number of pixels
+var ErrSize = errors.New("barcode: invalid size")
 
-	bufer := bytes.NewBuffer([]byte{})
-
-	// encode the barcode as png
-	png.Encode(bufer, qrCode)
+func CreateQR(input string, size int64) ([]byte, error) {
+	qrCode, err := qr.Encode(input, qr.M, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+	qrCode, err = barcode.Scale(qrCode, int(size), int(size))
+	if err != nil {
+		return nil, err
+	}
 
-	return ImageToWBMP(bufer.Bytes(), size)
+	return ImageToWBMP(qrCode, size)
 }
 
-func CreateAztec(input string, size int64) []byte {
-	aztecCode, _ := aztec.Encode([]byte(input), 0, 0)
-	aztecCode, _ = barcode.Scale(aztecCode, int(size), int(size))
-
-	bufer := bytes.NewBuffer([]byte{})
-
-	// encode the barcode as png
-	png.Encode(bufer, aztecCode)
+func CreateAztec(input string, size int64) ([]byte, error) {
+	aztecCode, err := aztec.Encode([]byte(input), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	aztecCode, err = barcode.Scale(aztecCode, int(size), int(size))
+	if err != nil {
+		return nil, err
+	}
 
-	return ImageToWBMP(bufer.Bytes(), size)
+	return ImageToWBMP(aztecCode, size)
 }
 
-func CreateCode128(input string, size int64) []byte {
+func CreateCode128(input string, size int64) ([]byte, error) {
 	bcode, err := code128.Encode(input)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	bcodeScaled, err := barcode.Scale(bcode, 101, int(size))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	bufer := bytes.NewBuffer([]byte{})
-
-	// encode the barcode as png
-	err = png.Encode(bufer, bcodeScaled)
-	if err != nil {
-		panic(err)
-	}
-
-	return ImageToWBMP(bufer.Bytes(), size)
+	return ImageToWBMP(bcodeScaled, size)
 }
 
-func ImageToWBMP(input []byte, size int64) []byte {
-	imagick.Initialize()
-	defer imagick.Terminate()
-
-	tmpdir, _ := os.MkdirTemp("", "imagick")
-	defer os.RemoveAll(tmpdir)
-
-	// write the image to a file
-	err := os.WriteFile(tmpdir+"/image.png", input, 0644)
-	if err != nil {
-		panic(err)
+// ImageToWBMP resizes a black and white image to size pixels wide, keeping
+// the aspect ratio, and encodes it as WBMP
+func ImageToWBMP(input image.Image, size int64) ([]byte, error) {
+	b := input.Bounds()
+	if size < 1 || b.Empty() {
+		return nil, ErrSize
 	}
-
-	_, err = imagick.ConvertImageCommand([]string{"convert", tmpdir + "/image.png", "-resize", fmt.Sprintf("%d", size), "-monochrome", tmpdir + "/output.bmp"})
-	if err != nil {
-		panic(err)
+	height := int(int64(b.Dy()) * size / int64(b.Dx()))
+	if height < 1 {
+		height = 1
 	}
-	imagick.ConvertImageCommand([]string{"convert", tmpdir + "/output.bmp", "-resize", fmt.Sprintf("%d", size), tmpdir + "/output.wbmp"})
 
-	// read the image from the file
-	output, err := os.ReadFile(tmpdir + "/output.wbmp")
-	if err != nil {
-		panic(err)
+	// the barcode images only implement At, copy into a plain grayscale image first
+	src := image.NewGray(b)
+	draw.Draw(src, b, input, b.Min, draw.Src)
+
+	// nearest neighbour keeps the bars crisp, anything smoother would need dithering
+	scaled := image.NewGray(image.Rect(0, 0, int(size), height))
+	draw.NearestNeighbor.Scale(scaled, scaled.Bounds(), src, b, draw.Src, nil)
+
+	bufer := bytes.NewBuffer([]byte{})
+	if err := wbmp.Encode(bufer, scaled); err != nil {
+		return nil, err
 	}
 
-	return output
+	return bufer.Bytes(), nil
 }
@@ -0,0 +1,57 @@
+package barcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wbmp"
+)
+
+func TestCreate(t *testing.T) {
+	creators := map[string]func(string, int64) ([]byte, error){
+		"qr":      CreateQR,
+		"aztec":   CreateAztec,
+		"code128": CreateCode128,
+	}
+	for name, create := range creators {
+		for _, size := range []int64{120, 480} {
+			out, err := create("hello", size)
+			if err != nil {
+				t.Errorf("%s at %d: %v", name, size, err)
+				continue
+			}
+			cfg, err := wbmp.DecodeConfig(bytes.NewReader(out))
+			if err != nil {
+				t.Errorf("%s at %d: %v", name, size, err)
+				continue
+			}
+			if int64(cfg.Width) != size {
+				t.Errorf("%s at %d is %d pixels wide", name, size, cfg.Width)
+			}
+		}
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	// a QR code needs at least 21 pixels, code128 cannot encode every rune
+	if _, err := CreateQR("hello", 16); err == nil {
+		t.Error("CreateQR smaller than the code did not fail")
+	}
+	if _, err := CreateAztec("hello", 0); err == nil {
+		t.Error("CreateAztec with size 0 did not fail")
+	}
+	if _, err := CreateCode128("héllo☃", 60); err == nil {
+		t.Error("CreateCode128 of an unencodable string did not fail")
+	}
+
+	for _, size := range []int64{0, -1} {
+		if _, err := ImageToWBMP(image.NewGray(image.Rect(0, 0, 10, 10)), size); !errors.Is(err, ErrSize) {
+			t.Errorf("ImageToWBMP at %d = %v, want ErrSize", size, err)
+		}
+	}
+	if _, err := ImageToWBMP(image.NewGray(image.Rect(0, 0, 0, 0)), 60); !errors.Is(err, ErrSize) {
+		t.Errorf("ImageToWBMP of an empty image = %v, want ErrSize", err)
+	}
+}
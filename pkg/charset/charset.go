@@ -0,0 +1,181 @@
+// Package charset moves decks between the UTF-8 our templates produce and
+// the ISO-8859-1 most WAP 1.x phones expect, or plain ASCII for the few
+// that take nothing else
+package charset
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	UTF8   = "utf-8"
+	Latin1 = "iso-8859-1"
+	ASCII  = "us-ascii"
+)
+
+// Negotiate picks UTF-8, ISO-8859-1 or US-ASCII from an Accept-Charset header,
+// fallback is used when the header is missing or names neither
+func Negotiate(acceptCharset, fallback string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptCharset, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		switch name {
+		case "utf-8", "utf8":
+			name = UTF8
+		case "iso-8859-1", "latin1", "iso_8859-1":
+			name = Latin1
+		case "us-ascii", "ascii":
+			name = ASCII
+		case "*":
+			name = fallback
+		default:
+			continue
+		}
+		// ties go to the first listed, phones list their favourite first
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+
+	if best == "" {
+		return fallback
+	}
+	return best
+}
+
+// fallbacks are ASCII spellings for common characters outside ISO-8859-1
+var fallbacks = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'",
+	'“': `"`, '”': `"`, '„': `"`, '‟': `"`, '″': `"`,
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'…': "...", '•': "*", '·': "*", '€': "EUR", '™': "(TM)",
+	'←': "<-", '→': "->", '↑': "^", '↓': "v", '✓': "v", '✔': "v", '✗': "x", '✘': "x",
+	'\u2002': " ", '\u2003': " ", '\u2009': " ", '\u200a': " ", '\u202f': " ",
+	'«': `"`, '»': `"`, '¡': "!", '¿': "?", '×': "x", '÷': "/", '\u00a0': " ", '\u00ad': "",
+	'©': "(C)", '®': "(R)", '£': "GBP", '¥': "JPY",
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Ø': "O", 'ø': "o", 'Þ': "Th", 'þ': "th", 'Ð': "D", 'ð': "d",
+	'Œ': "OE", 'œ': "oe", 'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'ı': "i",
+	'☺': ":)", '🙂': ":)", '😀': ":D", '😃': ":D", '😉': ";)", '🙁': ":(", '😢': ":'(", '❤': "<3",
+}
+
+// Transliterate returns an ASCII stand-in for r, empty when there is no
+// sensible one (emoji and other pictographs are dropped)
+func Transliterate(r rune) string {
+	if s, ok := fallbacks[r]; ok {
+		return s
+	}
+	// strip accents: ő decomposes into o and a combining mark
+	var base strings.Builder
+	for _, d := range norm.NFD.String(string(r)) {
+		if d < 0x80 {
+			base.WriteRune(d)
+		}
+	}
+	if base.Len() > 0 {
+		return base.String()
+	}
+	if isPictograph(r) || unicode.In(r, unicode.So, unicode.Mn, unicode.Cf) {
+		return ""
+	}
+	return "?"
+}
+
+// supported reports whether r can be sent as is in cs
+func supported(r rune, cs string) bool {
+	switch cs {
+	case Latin1:
+		return r <= 0xff
+	case ASCII:
+		return r < 0x80
+	}
+	// phones with UTF-8 still lack fonts for emoji
+	return !isPictograph(r)
+}
+
+// isPictograph reports emoji and the joiners and selectors that glue them together
+func isPictograph(r rune) bool {
+	return r > 0xffff || (r >= 0x2600 && r <= 0x27bf) || r == 0xfe0f || r == 0x200d
+}
+
+var (
+	charRef = regexp.MustCompile(`&#(x[0-9a-fA-F]+|[0-9]+);`)
+	xmlDecl = regexp.MustCompile(`^\s*<\?xml[^?]*\?>`)
+	declEnc = regexp.MustCompile(`\s+encoding="[^"]*"|\s+encoding='[^']*'`)
+)
+
+// escape keeps a transliteration from breaking markup, a smart quote
+// inside an attribute becomes a plain one
+var escape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+// EncodeDeck converts a UTF-8 deck to cs, characters cs or the phone
+// cannot show are transliterated and the XML declaration names cs
+func EncodeDeck(deck []byte, cs string) []byte {
+	out := make([]byte, 0, len(deck))
+	for len(deck) > 0 {
+		r, size := utf8.DecodeRune(deck)
+		deck = deck[size:]
+
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			// not UTF-8 to begin with, nothing sensible to send
+			out = append(out, '?')
+		case supported(r, cs):
+			if cs == Latin1 || cs == ASCII {
+				out = append(out, byte(r))
+			} else {
+				out = utf8.AppendRune(out, r)
+			}
+		default:
+			out = append(out, escape.Replace(Transliterate(r))...)
+		}
+	}
+
+	// character references are fine for the parser but not for the phone's font
+	out = charRef.ReplaceAllFunc(out, func(ref []byte) []byte {
+		digits := string(ref[2 : len(ref)-1])
+		var n int64
+		var err error
+		if digits[0] == 'x' {
+			n, err = strconv.ParseInt(digits[1:], 16, 32)
+		} else {
+			n, err = strconv.ParseInt(digits, 10, 32)
+		}
+		if err != nil || supported(rune(n), cs) {
+			return ref
+		}
+		return []byte(escape.Replace(Transliterate(rune(n))))
+	})
+
+	return setDeclaration(out, cs)
+}
+
+// setDeclaration makes the XML declaration name cs, adding one when missing
+func setDeclaration(deck []byte, cs string) []byte {
+	loc := xmlDecl.FindIndex(deck)
+	if loc == nil {
+		return append([]byte(`<?xml version="1.0" encoding="`+cs+`"?>`+"\n"), deck...)
+	}
+
+	decl := declEnc.ReplaceAll(deck[loc[0]:loc[1]], nil)
+	decl = bytes.Replace(decl, []byte("?>"), []byte(` encoding="`+cs+`"?>`), 1)
+
+	out := make([]byte, 0, len(deck)+len(cs)+12)
+	out = append(out, deck[:loc[0]]...)
+	out = append(out, decl...)
+	return append(out, deck[loc[1]:]...)
+}
@@ -0,0 +1,90 @@
+package charset
+
+import (
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header   string
+		fallback string
+		want     string
+	}{
+		{"", Latin1, Latin1},
+		{"", UTF8, UTF8},
+		{"utf-8", Latin1, UTF8},
+		{"UTF-8, ISO-8859-1", Latin1, UTF8},
+		{"iso-8859-1, utf-8", UTF8, Latin1},
+		{"utf-8;q=0.5, iso-8859-1", UTF8, Latin1},
+		{"iso-8859-1;q=0.2, utf-8;q=0.7", Latin1, UTF8},
+		{"iso-8859-1;q=0.7, utf-8;q=0.7", UTF8, Latin1},
+		{"utf-8;q=0, iso-8859-1;q=0.1", UTF8, Latin1},
+		{"us-ascii", UTF8, ASCII},
+		{"US-ASCII;q=0.9, iso-8859-1;q=0.5", UTF8, ASCII},
+		{"us-ascii;q=0.1, utf-8", Latin1, UTF8},
+		{"shift_jis, big5", Latin1, Latin1},
+		{"*", UTF8, UTF8},
+		{"iso-8859-1;q=0.5, *", UTF8, UTF8},
+		{"utf-8;q=bogus", Latin1, UTF8},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.header, tt.fallback); got != tt.want {
+			t.Errorf("Negotiate(%q, %s) = %s, want %s", tt.header, tt.fallback, got, tt.want)
+		}
+	}
+}
+
+func TestTransliterate(t *testing.T) {
+	tests := map[rune]string{
+		'é': "e", 'Ő': "O", 'ç': "c", 'ß': "ss", 'æ': "ae", 'ø': "o",
+		'€': "EUR", '“': `"`, '—': "-", '…': "...", '«': `"`, ' ': " ",
+		'😀': ":D", '🚆': "", '☃': "", '‍': "", '中': "?",
+	}
+	for r, want := range tests {
+		if got := Transliterate(r); got != want {
+			t.Errorf("Transliterate(%q) = %q, want %q", r, got, want)
+		}
+	}
+}
+
+func TestEncodeDeck(t *testing.T) {
+	const deck = `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+		`<wml><card title="“Café”"><p>Ça coûte 5€ — naïve 🚆 &#8364; &#233; &#x1F600;</p></card></wml>`
+
+	tests := []struct {
+		cs   string
+		want string
+	}{
+		{UTF8, `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+			`<wml><card title="“Café”"><p>Ça coûte 5€ — naïve  &#8364; &#233; :D</p></card></wml>`},
+		{Latin1, `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n" +
+			"<wml><card title=\"&quot;Caf\xe9&quot;\"><p>\xc7a co\xfbte 5EUR - na\xefve  EUR &#233; :D</p></card></wml>"},
+		{ASCII, `<?xml version="1.0" encoding="us-ascii"?>` + "\n" +
+			`<wml><card title="&quot;Cafe&quot;"><p>Ca coute 5EUR - naive  EUR e :D</p></card></wml>`},
+	}
+	for _, tt := range tests {
+		got := string(EncodeDeck([]byte(deck), tt.cs))
+		if got != tt.want {
+			t.Errorf("EncodeDeck(%s) =\n%q\nwant\n%q", tt.cs, got, tt.want)
+		}
+		if tt.cs == ASCII {
+			for i := range len(got) {
+				if got[i] >= 0x80 {
+					t.Errorf("us-ascii deck has byte %#x at %d", got[i], i)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeDeckDeclaration(t *testing.T) {
+	got := string(EncodeDeck([]byte("<wml/>"), Latin1))
+	if want := `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n<wml/>"; got != want {
+		t.Errorf("EncodeDeck without a declaration = %q, want %q", got, want)
+	}
+	got = string(EncodeDeck([]byte(`<?xml version='1.0' encoding='ISO-8859-1'?><wml/>`), UTF8))
+	if want := `<?xml version='1.0' encoding="utf-8"?><wml/>`; got != want {
+		t.Errorf("EncodeDeck replacing the encoding = %q, want %q", got, want)
+	}
+}
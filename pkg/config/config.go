@@ -0,0 +1,327 @@
+// Package config holds the settings of a server instance, read from a YAML
+// file and overridden by environment variables, so one binary can run as
+// staging and as production
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Links     Links     `yaml:"links"`
+	Images    Images    `yaml:"images"`
+	Devices   Devices   `yaml:"devices"`
+	News      News      `yaml:"news"`
+	Weather   Weather   `yaml:"weather"`
+	Navigator Navigator `yaml:"navigator"`
+}
+
+type Server struct {
+	// Listen is the address the HTTP server binds to
+	Listen    string `yaml:"listen"`
+	StaticDir string `yaml:"static_dir"`
+	// DevMode reloads templates when they change on disk
+	DevMode bool `yaml:"dev_mode"`
+	// WMLStrict replaces invalid decks with an error card
+	WMLStrict bool `yaml:"wml_strict"`
+	// DrainPeriod is how long /ready reports unready on shutdown before
+	// connections are closed, give the load balancer at least one probe interval
+	DrainPeriod time.Duration `yaml:"drain_period"`
+}
+
+type Links struct {
+	// Store is "memory" or "bolt"
+	Store string `yaml:"store"`
+	// Path of the bolt database
+	Path string `yaml:"path"`
+	// Key derives the link IDs, give every replica the same key
+	Key string `yaml:"key"`
+}
+
+type Images struct {
+	CacheDir string `yaml:"cache_dir"`
+	// CacheMaxMB caps the converted images kept on disk, the oldest are deleted first
+	CacheMaxMB int `yaml:"cache_max_mb"`
+}
+
+type Devices struct {
+	UAProfCacheDir string `yaml:"uaprof_cache_dir"`
+}
+
+type News struct {
+	// Feeds are listed on the news index in this order, the first one is the default
+	Feeds []Feed `yaml:"feeds"`
+	// ReaderURL is the W@PFind instance that turns articles into WML
+	ReaderURL string `yaml:"reader_url"`
+	// StorePath is the bbolt database articles are kept in
+	StorePath    string        `yaml:"store_path"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Retention is how long articles stay readable after they left their feed
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Feed is an RSS, Atom or JSON Feed news source
+type Feed struct {
+	// ID names the feed in URLs: /nws/list?feed=vrt
+	ID       string `yaml:"id"`
+	Title    string `yaml:"title"`
+	URL      string `yaml:"url"`
+	Language string `yaml:"language"`
+	// SkipTitles are regular expressions, items with a matching title are left out
+	SkipTitles []string `yaml:"skip_titles"`
+}
+
+type Weather struct {
+	GeocodingURL string `yaml:"geocoding_url"`
+	ForecastURL  string `yaml:"forecast_url"`
+}
+
+type Navigator struct {
+	// APIURL points at a db-rest instance
+	APIURL       string `yaml:"api_url"`
+	StationsFile string `yaml:"stations_file"`
+	// Timezone the timetables are shown in
+	Timezone string `yaml:"timezone"`
+}
+
+// Default is what runs without a config file
+func Default() Config {
+	return Config{
+		Server: Server{
+			Listen:      ":8080",
+			StaticDir:   "./static",
+			DrainPeriod: 10 * time.Second,
+		},
+		Links: Links{
+			Store: "memory",
+			Path:  "./links.db",
+		},
+		Images: Images{
+			CacheDir:   "./cache/images",
+			CacheMaxMB: 512,
+		},
+		Devices: Devices{
+			UAProfCacheDir: "./cache/uaprof",
+		},
+		News: News{
+			Feeds: []Feed{{
+				ID:       "vrt",
+				Title:    "VRT NWS",
+				URL:      "https://www.vrt.be/vrtnws/nl.rss.articles.xml",
+				Language: "nl",
+				// the weather and the TV news are videos
+				SkipTitles: []string{"^Het weer", "^Het Journaal"},
+			}},
+			ReaderURL:    "http://find.bevelgacom.be/",
+			StorePath:    "./news.db",
+			PollInterval: 10 * time.Minute,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Weather: Weather{
+			GeocodingURL: "https://geocoding-api.open-meteo.com/v1/search",
+			ForecastURL:  "https://api.open-meteo.com/v1/forecast",
+		},
+		Navigator: Navigator{
+			APIURL:       "http://localhost:3000",
+			StationsFile: "./stations.csv",
+			Timezone:     "Europe/Berlin",
+		},
+	}
+}
+
+// Load reads the YAML file at path on top of the defaults, an empty path
+// skips the file. Environment variables win over both
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// env maps environment variables onto the settings they override
+func (c *Config) env() map[string]any {
+	return map[string]any{
+		"LISTEN_ADDR":             &c.Server.Listen,
+		"STATIC_DIR":              &c.Server.StaticDir,
+		"DEV_MODE":                &c.Server.DevMode,
+		"WML_STRICT":              &c.Server.WMLStrict,
+		"DRAIN_PERIOD":            &c.Server.DrainPeriod,
+		"LINK_STORE":              &c.Links.Store,
+		"LINK_STORE_PATH":         &c.Links.Path,
+		"LINK_CACHE_KEY":          &c.Links.Key,
+		"IMAGE_CACHE_DIR":         &c.Images.CacheDir,
+		"IMAGE_CACHE_MAX_MB":      &c.Images.CacheMaxMB,
+		"UAPROF_CACHE_DIR":        &c.Devices.UAProfCacheDir,
+		"NEWS_READER_URL":         &c.News.ReaderURL,
+		"NEWS_STORE_PATH":         &c.News.StorePath,
+		"NEWS_POLL_INTERVAL":      &c.News.PollInterval,
+		"NEWS_RETENTION":          &c.News.Retention,
+		"WEATHER_GEOCODING_URL":   &c.Weather.GeocodingURL,
+		"WEATHER_FORECAST_URL":    &c.Weather.ForecastURL,
+		"NAVIGATOR_API_URL":       &c.Navigator.APIURL,
+		"NAVIGATOR_STATIONS_FILE": &c.Navigator.StationsFile,
+		"NAVIGATOR_TIMEZONE":      &c.Navigator.Timezone,
+	}
+}
+
+func (c *Config) applyEnv() error {
+	for name, field := range c.env() {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		switch f := field.(type) {
+		case *string:
+			*f = value
+		case *int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*f = n
+		case *bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*f = b
+		case *time.Duration:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*f = d
+		}
+	}
+	return nil
+}
+
+// Validate reports every setting that would stop the server from working
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Listen == "" {
+		errs = append(errs, errors.New("server.listen is empty"))
+	}
+	if info, err := os.Stat(c.Server.StaticDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("server.static_dir %q is not a directory", c.Server.StaticDir))
+	}
+	if c.Server.DrainPeriod < 0 {
+		errs = append(errs, errors.New("server.drain_period must not be negative"))
+	}
+
+	switch c.Links.Store {
+	case "memory":
+	case "bolt":
+		if c.Links.Path == "" {
+			errs = append(errs, errors.New("links.path is needed for the bolt store"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("links.store %q is not memory or bolt", c.Links.Store))
+	}
+
+	if c.Images.CacheDir == "" {
+		errs = append(errs, errors.New("images.cache_dir is empty"))
+	}
+	if c.Images.CacheMaxMB <= 0 {
+		errs = append(errs, errors.New("images.cache_max_mb must be positive"))
+	}
+	if c.Devices.UAProfCacheDir == "" {
+		errs = append(errs, errors.New("devices.uaprof_cache_dir is empty"))
+	}
+
+	for _, u := range []struct{ name, value string }{
+		{"news.reader_url", c.News.ReaderURL},
+		{"weather.geocoding_url", c.Weather.GeocodingURL},
+		{"weather.forecast_url", c.Weather.ForecastURL},
+		{"navigator.api_url", c.Navigator.APIURL},
+	} {
+		if err := checkURL(u.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.name, err))
+		}
+	}
+
+	errs = append(errs, c.News.validate()...)
+
+	if _, err := time.LoadLocation(c.Navigator.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("navigator.timezone: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+var feedID = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func (n *News) validate() []error {
+	var errs []error
+	if n.StorePath == "" {
+		errs = append(errs, errors.New("news.store_path is empty"))
+	}
+	if n.PollInterval < time.Minute {
+		errs = append(errs, errors.New("news.poll_interval must be at least 1m, be nice to the feeds"))
+	}
+	if n.Retention <= 0 {
+		errs = append(errs, errors.New("news.retention must be positive"))
+	}
+	if len(n.Feeds) == 0 {
+		return append(errs, errors.New("news.feeds is empty"))
+	}
+
+	seen := map[string]bool{}
+	for i, f := range n.Feeds {
+		if !feedID.MatchString(f.ID) {
+			errs = append(errs, fmt.Errorf("news.feeds[%d].id %q must be lowercase letters, digits and dashes", i, f.ID))
+		}
+		if seen[f.ID] {
+			errs = append(errs, fmt.Errorf("news.feeds[%d].id %q is used twice", i, f.ID))
+		}
+		seen[f.ID] = true
+		if err := checkURL(f.URL); err != nil {
+			errs = append(errs, fmt.Errorf("news.feeds[%d].url: %w", i, err))
+		}
+		for _, expr := range f.SkipTitles {
+			if _, err := regexp.Compile(expr); err != nil {
+				errs = append(errs, fmt.Errorf("news.feeds[%d].skip_titles: %w", i, err))
+			}
+		}
+	}
+	return errs
+}
+
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file with static_dir pointing at an existing
+// directory followed by extra, and returns its path
+func writeConfig(t *testing.T, extra string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	data := "server:\n  static_dir: " + dir + "\n" + extra
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("STATIC_DIR", t.TempDir())
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	if cfg.Server.Listen != want.Server.Listen || cfg.Links.Store != "memory" ||
+		cfg.Images.CacheMaxMB != 512 || cfg.Server.DrainPeriod != 10*time.Second {
+		t.Errorf("Load without a file = %+v", cfg)
+	}
+	if len(cfg.News.Feeds) != 1 || cfg.News.Feeds[0].ID != "vrt" {
+		t.Errorf("default feeds = %+v", cfg.News.Feeds)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `  listen: ":9090"
+  wml_strict: true
+links:
+  store: bolt
+  path: /tmp/links.db
+news:
+  poll_interval: 5m
+  feeds:
+    - id: hln
+      title: HLN
+      url: https://www.hln.be/rss.xml
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Listen != ":9090" || !cfg.Server.WMLStrict || cfg.Links.Store != "bolt" {
+		t.Errorf("server and links = %+v %+v", cfg.Server, cfg.Links)
+	}
+	if cfg.News.PollInterval != 5*time.Minute || len(cfg.News.Feeds) != 1 || cfg.News.Feeds[0].ID != "hln" {
+		t.Errorf("news = %+v", cfg.News)
+	}
+	// settings the file leaves out keep their default
+	if cfg.News.Retention != 7*24*time.Hour || cfg.Images.CacheDir != "./cache/images" {
+		t.Errorf("defaults were lost: %+v %+v", cfg.News, cfg.Images)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := writeConfig(t, "  listen: \":9090\"\n")
+	t.Setenv("LISTEN_ADDR", ":7070")
+	t.Setenv("DEV_MODE", "true")
+	t.Setenv("DRAIN_PERIOD", "3s")
+	t.Setenv("IMAGE_CACHE_MAX_MB", "64")
+	t.Setenv("LINK_CACHE_KEY", "secret")
+	// empty variables are ignored
+	t.Setenv("NAVIGATOR_TIMEZONE", "")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Listen != ":7070" {
+		t.Errorf("listen = %q, the environment wins over the file", cfg.Server.Listen)
+	}
+	if !cfg.Server.DevMode || cfg.Server.DrainPeriod != 3*time.Second || cfg.Images.CacheMaxMB != 64 || cfg.Links.Key != "secret" {
+		t.Errorf("environment not applied: %+v %+v %+v", cfg.Server, cfg.Images, cfg.Links)
+	}
+	if cfg.Navigator.Timezone != "Europe/Berlin" {
+		t.Errorf("timezone = %q", cfg.Navigator.Timezone)
+	}
+}
+
+func TestLoadEnvInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"DEV_MODE":           "maybe",
+		"DRAIN_PERIOD":       "10",
+		"IMAGE_CACHE_MAX_MB": "lots",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := Load(writeConfig(t, "")); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("Load with %s=%s: %v", name, value, err)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra string
+		want  []string
+	}{
+		{"unknown field", "  port: 80\n", []string{"field port not found"}},
+		{"drain period", "  drain_period: -1s\n", []string{"server.drain_period"}},
+		{"link store", "links:\n  store: redis\n", []string{`links.store "redis"`}},
+		{"bolt path", "links:\n  store: bolt\n  path: \"\"\n", []string{"links.path"}},
+		{"image cache", "images:\n  cache_max_mb: 0\n", []string{"images.cache_max_mb"}},
+		{"urls", "weather:\n  forecast_url: ftp://example.com\nnavigator:\n  api_url: \"\"\n",
+			[]string{"weather.forecast_url", "navigator.api_url"}},
+		{"timezone", "navigator:\n  timezone: Mars/Olympus\n", []string{"navigator.timezone"}},
+		{"poll interval", "news:\n  poll_interval: 10s\n", []string{"news.poll_interval"}},
+		{"no feeds", "news:\n  feeds: []\n", []string{"news.feeds is empty"}},
+		{"feeds", `news:
+  feeds:
+    - id: Bad_ID
+      url: https://example.com/a.xml
+    - id: dup
+      url: https://example.com/b.xml
+    - id: dup
+      url: example.com/c.xml
+      skip_titles: ["("]
+`, []string{"news.feeds[0].id", "news.feeds[2].id \"dup\" is used twice", "news.feeds[2].url", "news.feeds[2].skip_titles"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.extra))
+			if err == nil {
+				t.Fatal("Load succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadMissingStaticDir(t *testing.T) {
+	t.Setenv("STATIC_DIR", filepath.Join(t.TempDir(), "missing"))
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "server.static_dir") {
+		t.Errorf("Load = %v", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Load of a missing file = %v", err)
+	}
+}
@@ -746,6 +746,9 @@ type GetJourneysParams struct {
 	ToLatitude  *float32 `form:"to.latitude,omitempty" json:"to.latitude,omitempty"`
 	ToLongitude *float32 `form:"to.longitude,omitempty" json:"to.longitude,omitempty"`
 
+	// Via Compute only journeys that pass through this stop/station ID.
+	Via *string `form:"via,omitempty" json:"via,omitempty"`
+
 	// Departure Compute journeys departing at this date/time. Mutually exclusive with `arrival`. – Default: *now*
 	Departure *time.Time `form:"departure,omitempty" json:"departure,omitempty"`
 
@@ -3323,6 +3326,22 @@ func NewGetJourneysRequest(server string, params *GetJourneysParams) (*http.Requ
 
 		}
 
+		if params.Via != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "via", runtime.ParamLocationQuery, *params.Via); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
 		if params.Departure != nil {
 
 			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "departure", runtime.ParamLocationQuery, *params.Departure); err != nil {
@@ -0,0 +1,121 @@
+// Package device works out what the phone on the other end can handle
+// from its User-Agent, Accept and x-wap-profile (UAProf) headers
+package device
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/charset"
+)
+
+// Profile describes the capabilities of a phone
+type Profile struct {
+	Name         string
+	ScreenWidth  int
+	ScreenHeight int
+	// MaxDeckSize is the largest compiled (WBXML) deck in bytes the phone accepts
+	MaxDeckSize int
+	Color       bool
+	WBMP        bool
+	PNG         bool
+	JPEG        bool
+	GIF         bool
+	// WMLC is set for phones that take compiled WML without a gateway in between
+	WMLC       bool
+	WMLVersion string
+	// Dither is the preferred dithering algorithm for WBMP images
+	Dither string
+	// Charset is what decks are sent in, charset.Latin1, charset.UTF8 or charset.ASCII
+	Charset string
+}
+
+// Default is the Nokia 7110 standard, if it works there it works everywhere
+var Default = Profile{
+	Name:         "default",
+	ScreenWidth:  96,
+	ScreenHeight: 65,
+	MaxDeckSize:  1397,
+	WBMP:         true,
+	WMLVersion:   "1.1",
+	Dither:       "atkinson",
+	Charset:      charset.Latin1,
+}
+
+// knownDevices maps User-Agent prefixes onto profiles, for phones that do not send UAProf
+var knownDevices = []struct {
+	prefix  string
+	profile Profile
+}{
+	{"Nokia7110", Default},
+	{"Nokia6210", Profile{ScreenWidth: 96, ScreenHeight: 60, MaxDeckSize: 1397, WBMP: true, WMLVersion: "1.1", Dither: "atkinson", Charset: charset.Latin1}},
+	{"Nokia6250", Profile{ScreenWidth: 96, ScreenHeight: 60, MaxDeckSize: 1397, WBMP: true, WMLVersion: "1.1", Dither: "atkinson", Charset: charset.Latin1}},
+	{"Nokia3330", Profile{ScreenWidth: 84, ScreenHeight: 48, MaxDeckSize: 2800, WBMP: true, WMLVersion: "1.1", Dither: "atkinson", Charset: charset.Latin1}},
+	{"Nokia8310", Profile{ScreenWidth: 84, ScreenHeight: 48, MaxDeckSize: 2800, WBMP: true, WMLVersion: "1.2", Dither: "atkinson", Charset: charset.Latin1}},
+	{"Nokia3510i", Profile{ScreenWidth: 96, ScreenHeight: 65, MaxDeckSize: 3500, Color: true, WBMP: true, PNG: true, JPEG: true, GIF: true, WMLVersion: "1.3", Dither: "fs", Charset: charset.UTF8}},
+	{"Nokia7650", Profile{ScreenWidth: 176, ScreenHeight: 208, MaxDeckSize: 20000, Color: true, WBMP: true, PNG: true, JPEG: true, GIF: true, WMLVersion: "1.3", Dither: "fs", Charset: charset.UTF8}},
+	{"Nokia6600", Profile{ScreenWidth: 176, ScreenHeight: 208, MaxDeckSize: 20000, Color: true, WBMP: true, PNG: true, JPEG: true, GIF: true, WMLVersion: "1.3", Dither: "fs", Charset: charset.UTF8}},
+	{"Ericsson", Profile{ScreenWidth: 101, ScreenHeight: 65, MaxDeckSize: 2000, WBMP: true, WMLVersion: "1.1", Dither: "atkinson", Charset: charset.Latin1}},
+	{"SIE-", Profile{ScreenWidth: 101, ScreenHeight: 64, MaxDeckSize: 2000, WBMP: true, WMLVersion: "1.1", Dither: "atkinson", Charset: charset.Latin1}},
+	{"MOT-", Profile{ScreenWidth: 96, ScreenHeight: 64, MaxDeckSize: 1400, WBMP: true, WMLVersion: "1.1", Dither: "atkinson", Charset: charset.Latin1}},
+}
+
+// FromUserAgent returns the built-in profile for a User-Agent, or Default if the phone is unknown
+func FromUserAgent(ua string) Profile {
+	for _, d := range knownDevices {
+		if strings.HasPrefix(ua, d.prefix) {
+			p := d.profile
+			p.Name = d.prefix
+			return p
+		}
+	}
+
+	return Default
+}
+
+// applyAccept turns on formats the phone explicitly accepts
+func applyAccept(p *Profile, accept string) {
+	accept = strings.ToLower(accept)
+	if strings.Contains(accept, "image/vnd.wap.wbmp") {
+		p.WBMP = true
+	}
+	if strings.Contains(accept, "image/png") {
+		p.PNG = true
+	}
+	if strings.Contains(accept, "image/jpeg") || strings.Contains(accept, "image/jpg") {
+		p.JPEG = true
+	}
+	if strings.Contains(accept, "image/gif") {
+		p.GIF = true
+	}
+	if strings.Contains(accept, "application/vnd.wap.wmlc") {
+		p.WMLC = true
+	}
+}
+
+// Vary names the request headers a profile is detected from, responses
+// that depend on the profile list them in their Vary header so shared
+// caches do not hand them to other phones
+const Vary = "User-Agent, Accept, Accept-Charset, X-Wap-Profile, Profile"
+
+// ProfileURL extracts the UAProf URL from the x-wap-profile or Profile header
+func ProfileURL(r *http.Request) string {
+	header := r.Header.Get("x-wap-profile")
+	if header == "" {
+		header = r.Header.Get("Profile")
+	}
+	// the header is a quoted URL, optionally followed by profile diffs we ignore
+	header = strings.TrimSpace(header)
+	if i := strings.IndexAny(header, " ,"); i > 0 && !strings.HasPrefix(header, `"`) {
+		header = header[:i]
+	}
+	if strings.HasPrefix(header, `"`) {
+		if end := strings.Index(header[1:], `"`); end >= 0 {
+			header = header[1 : end+1]
+		}
+	}
+	if !strings.HasPrefix(header, "http://") && !strings.HasPrefix(header, "https://") {
+		return ""
+	}
+	return header
+}
@@ -0,0 +1,72 @@
+package device
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/charset"
+)
+
+func TestFromUserAgent(t *testing.T) {
+	tests := []struct {
+		ua         string
+		name       string
+		width      int
+		wmlVersion string
+	}{
+		{"Nokia7110/1.0 (05.01)", "Nokia7110", 96, "1.1"},
+		{"Nokia6600/1.0 (4.09.1) SymbianOS/7.0s", "Nokia6600", 176, "1.3"},
+		{"SIE-S45/4.0 UP/4.1.8c", "SIE-", 101, "1.1"},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "default", 96, "1.1"},
+		{"", "default", 96, "1.1"},
+	}
+	for _, tt := range tests {
+		p := FromUserAgent(tt.ua)
+		if p.Name != tt.name || p.ScreenWidth != tt.width || p.WMLVersion != tt.wmlVersion {
+			t.Errorf("FromUserAgent(%q) = %s %d wide WML %s, want %s %d wide WML %s", tt.ua, p.Name, p.ScreenWidth, p.WMLVersion, tt.name, tt.width, tt.wmlVersion)
+		}
+	}
+
+	// the profiles are copies, changing one does not change the table
+	p := FromUserAgent("Nokia6600")
+	p.ScreenWidth = 1
+	if FromUserAgent("Nokia6600").ScreenWidth != 176 {
+		t.Error("changing a returned profile changed the built-in one")
+	}
+}
+
+func TestProfileURL(t *testing.T) {
+	tests := []struct {
+		header, value string
+		want          string
+	}{
+		{"x-wap-profile", `"http://nds.nokia.com/uaprof/N6600r100.xml"`, "http://nds.nokia.com/uaprof/N6600r100.xml"},
+		{"x-wap-profile", `http://nds.nokia.com/uaprof/N6600r100.xml`, "http://nds.nokia.com/uaprof/N6600r100.xml"},
+		{"x-wap-profile", `"http://example.com/p.xml", "1-abcdef"`, "http://example.com/p.xml"},
+		{"x-wap-profile", `http://example.com/p.xml, "1-abcdef"`, "http://example.com/p.xml"},
+		{"Profile", `"https://example.com/p.rdf"`, "https://example.com/p.rdf"},
+		{"x-wap-profile", `"file:///etc/passwd"`, ""},
+		{"x-wap-profile", `"`, ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := ProfileURL(r); got != tt.want {
+			t.Errorf("ProfileURL(%s: %s) = %q, want %q", tt.header, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestApplyAccept(t *testing.T) {
+	p := Default
+	applyAccept(&p, "text/vnd.wap.wml, Image/PNG, image/jpg, application/vnd.wap.wmlc")
+	if !p.PNG || !p.JPEG || !p.WMLC || p.GIF {
+		t.Errorf("applyAccept = png %v jpeg %v wmlc %v gif %v", p.PNG, p.JPEG, p.WMLC, p.GIF)
+	}
+	if p.Charset != charset.Latin1 {
+		t.Errorf("applyAccept changed the charset to %s", p.Charset)
+	}
+}
@@ -0,0 +1,332 @@
+package device
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/charset"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/safefetch"
+)
+
+// UAProf describes the capabilities a phone vendor published in its UAProf RDF,
+// zero values mean the document did not say
+type UAProf struct {
+	ScreenWidth  int
+	ScreenHeight int
+	ColorCapable *bool
+	WmlDeckSize  int
+	WMLVersions  []string
+	Accept       []string
+	Charsets     []string
+}
+
+// ParseUAProf reads the attributes we care about from a UAProf RDF document,
+// vendors were creative with namespaces so only local names are matched
+func ParseUAProf(r io.Reader) (*UAProf, error) {
+	prof := &UAProf{}
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	var stack []string
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+
+			// bag items belong to the attribute two levels up: <prf:CcppAccept><rdf:Bag><rdf:li>
+			if name == "li" && len(stack) >= 2 {
+				switch stack[len(stack)-2] {
+				case "CcppAccept":
+					prof.Accept = append(prof.Accept, strings.ToLower(value))
+				case "WmlVersion":
+					prof.WMLVersions = append(prof.WMLVersions, value)
+				case "CcppAccept-Charset":
+					prof.Charsets = append(prof.Charsets, strings.ToLower(value))
+				}
+				continue
+			}
+
+			switch name {
+			case "ScreenSize":
+				if w, h, ok := strings.Cut(strings.ToLower(value), "x"); ok {
+					prof.ScreenWidth, _ = strconv.Atoi(strings.TrimSpace(w))
+					prof.ScreenHeight, _ = strconv.Atoi(strings.TrimSpace(h))
+				}
+			case "ColorCapable":
+				color := strings.EqualFold(value, "yes")
+				prof.ColorCapable = &color
+			case "WmlDeckSize":
+				prof.WmlDeckSize, _ = strconv.Atoi(value)
+			}
+		}
+	}
+
+	return prof, nil
+}
+
+// clampWMLVersion maps a UAProf WmlVersion like "1.2" or "WML/2.0" onto the
+// 1.1 to 1.3 range we render, "" means it is not a version
+func clampWMLVersion(v string) string {
+	v = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(v)), "WML/")
+	major, minor, ok := strings.Cut(v, ".")
+	majorN, err := strconv.Atoi(major)
+	if !ok || err != nil {
+		return ""
+	}
+	// patch levels like 1.2.1 make no difference to the markup
+	minor, _, _ = strings.Cut(minor, ".")
+	minorN, err := strconv.Atoi(minor)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case majorN > 1 || majorN == 1 && minorN > 3:
+		return "1.3"
+	case majorN < 1 || minorN < 1:
+		return "1.1"
+	}
+	return "1." + strconv.Itoa(minorN)
+}
+
+// apply overrides the profile with everything the UAProf document specified
+func (u *UAProf) apply(p *Profile) {
+	if u.ScreenWidth > 0 && u.ScreenHeight > 0 {
+		p.ScreenWidth, p.ScreenHeight = u.ScreenWidth, u.ScreenHeight
+	}
+	if u.ColorCapable != nil {
+		p.Color = *u.ColorCapable
+	}
+	if u.WmlDeckSize > 0 {
+		p.MaxDeckSize = u.WmlDeckSize
+	}
+	for _, v := range u.WMLVersions {
+		if v := clampWMLVersion(v); v > p.WMLVersion {
+			p.WMLVersion = v
+		}
+	}
+	if len(u.Accept) > 0 {
+		applyAccept(p, strings.Join(u.Accept, ","))
+	}
+	if len(u.Charsets) > 0 {
+		p.Charset = charset.Negotiate(strings.Join(u.Charsets, ","), p.Charset)
+	}
+	if p.Color {
+		p.Dither = "fs"
+	}
+}
+
+const (
+	// maxProfiles is how many parsed UAProf documents are kept in memory,
+	// the header is sent by the client so it can name any URL
+	maxProfiles = 1000
+	// maxFetches and maxHostFetches limit the downloads running at once
+	maxFetches     = 8
+	maxHostFetches = 1
+	// failedRetry is how long a UAProf URL that failed is left alone
+	failedRetry  = time.Hour
+	fetchTimeout = 30 * time.Second
+)
+
+type uaprofEntry struct {
+	url  string
+	prof *UAProf
+	// retry is set for documents we could not fetch or parse, they are
+	// fetched again after it
+	retry time.Time
+}
+
+// Detector builds profiles for requests, UAProf documents are fetched in the
+// background and kept in a directory so we only download each one once
+type Detector struct {
+	dir     string
+	fetcher *safefetch.Fetcher
+
+	lock  sync.Mutex
+	lru   *list.List
+	byURL map[string]*list.Element
+	// pending are the URLs being downloaded, hosts counts them per host
+	pending map[string]bool
+	hosts   map[string]int
+}
+
+func NewDetector(dir string, fetcher *safefetch.Fetcher) (*Detector, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Detector{
+		dir:     dir,
+		fetcher: fetcher,
+		lru:     list.New(),
+		byURL:   map[string]*list.Element{},
+		pending: map[string]bool{},
+		hosts:   map[string]int{},
+	}, nil
+}
+
+// Detect returns the profile of the phone that sent r
+func (d *Detector) Detect(r *http.Request) Profile {
+	p := FromUserAgent(r.UserAgent())
+	applyAccept(&p, r.Header.Get("Accept"))
+
+	if u := ProfileURL(r); u != "" {
+		if prof := d.uaprof(u); prof != nil {
+			prof.apply(&p)
+		}
+	}
+	p.Charset = charset.Negotiate(r.Header.Get("Accept-Charset"), p.Charset)
+
+	return p
+}
+
+func (d *Detector) path(profileURL string) string {
+	sum := sha256.Sum256([]byte(profileURL))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".rdf")
+}
+
+// uaprof returns the parsed document for profileURL, nil means we do not have it (yet)
+func (d *Detector) uaprof(profileURL string) *UAProf {
+	d.lock.Lock()
+	if el, ok := d.byURL[profileURL]; ok {
+		entry := el.Value.(*uaprofEntry)
+		if entry.retry.IsZero() || time.Now().Before(entry.retry) {
+			d.lru.MoveToFront(el)
+			d.lock.Unlock()
+			return entry.prof
+		}
+		d.lru.Remove(el)
+		delete(d.byURL, profileURL)
+	}
+	d.lock.Unlock()
+
+	// every request takes the lock, keep the disk and the parser out of it
+	prof, err := d.load(profileURL)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err == nil {
+		d.remember(profileURL, prof, time.Time{})
+		return prof
+	}
+	d.startDownload(profileURL)
+	return nil
+}
+
+// load reads a document downloaded earlier, an invalid one is removed so it is fetched again
+func (d *Detector) load(profileURL string) (*UAProf, error) {
+	data, err := os.ReadFile(d.path(profileURL))
+	if err != nil {
+		return nil, err
+	}
+	prof, err := ParseUAProf(bytes.NewReader(data))
+	if err != nil {
+		log.Println("invalid UAProf", profileURL, err)
+		os.Remove(d.path(profileURL))
+		return nil, err
+	}
+	return prof, nil
+}
+
+// remember keeps a document in memory, the least recently used one goes
+// when we are full. d.lock must be held
+func (d *Detector) remember(profileURL string, prof *UAProf, retry time.Time) {
+	if el, ok := d.byURL[profileURL]; ok {
+		d.lru.Remove(el)
+	}
+	d.byURL[profileURL] = d.lru.PushFront(&uaprofEntry{url: profileURL, prof: prof, retry: retry})
+	for d.lru.Len() > maxProfiles {
+		el := d.lru.Back()
+		d.lru.Remove(el)
+		delete(d.byURL, el.Value.(*uaprofEntry).url)
+	}
+}
+
+// startDownload fetches profileURL in the background unless too many
+// downloads are running, a later request tries again. d.lock must be held
+func (d *Detector) startDownload(profileURL string) {
+	u, err := url.Parse(profileURL)
+	if err != nil {
+		d.remember(profileURL, nil, time.Now().Add(failedRetry))
+		return
+	}
+	if d.pending[profileURL] || len(d.pending) >= maxFetches || d.hosts[u.Host] >= maxHostFetches {
+		return
+	}
+
+	d.pending[profileURL] = true
+	d.hosts[u.Host]++
+	go d.download(profileURL, u.Host)
+}
+
+func (d *Detector) download(profileURL, host string) {
+	prof, data, err := d.fetch(profileURL)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.pending, profileURL)
+	if d.hosts[host]--; d.hosts[host] <= 0 {
+		delete(d.hosts, host)
+	}
+
+	if err != nil {
+		log.Println("failed to fetch UAProf", profileURL, err)
+		// a dead vendor site is not fetched again on every request
+		d.remember(profileURL, nil, time.Now().Add(failedRetry))
+		return
+	}
+
+	d.remember(profileURL, prof, time.Time{})
+	if err := os.WriteFile(d.path(profileURL), data, 0644); err != nil {
+		log.Println("failed to store UAProf", profileURL, err)
+	}
+}
+
+func (d *Detector) fetch(profileURL string) (*UAProf, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	resp, err := d.fetcher.Get(ctx, profileURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	prof, err := ParseUAProf(bytes.NewReader(resp.Body))
+	if err != nil {
+		return nil, nil, err
+	}
+	return prof, resp.Body, nil
+}
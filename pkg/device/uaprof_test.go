@@ -0,0 +1,128 @@
+package device
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const testUAProf = `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlns:prf="http://www.wapforum.org/profiles/UAPROF/ccppschema-20010430#">
+<rdf:Description rdf:ID="Profile">
+	<prf:component><rdf:Description rdf:ID="HardwarePlatform">
+		<prf:ScreenSize>176x208</prf:ScreenSize>
+		<prf:ColorCapable>Yes</prf:ColorCapable>
+	</rdf:Description></prf:component>
+	<prf:component><rdf:Description rdf:ID="SoftwarePlatform">
+		<prf:CcppAccept><rdf:Bag>
+			<rdf:li>image/PNG</rdf:li>
+			<rdf:li>application/vnd.wap.wmlc</rdf:li>
+		</rdf:Bag></prf:CcppAccept>
+		<prf:CcppAccept-Charset><rdf:Bag>
+			<rdf:li>UTF-8</rdf:li>
+			<rdf:li>ISO-8859-1</rdf:li>
+		</rdf:Bag></prf:CcppAccept-Charset>
+	</rdf:Description></prf:component>
+	<prf:component><rdf:Description rdf:ID="BrowserUA">
+		<prf:WmlDeckSize>20000</prf:WmlDeckSize>
+		<prf:WmlVersion><rdf:Bag>
+			<rdf:li>1.1</rdf:li>
+			<rdf:li>1.2.1</rdf:li>
+			<rdf:li>2.0</rdf:li>
+		</rdf:Bag></prf:WmlVersion>
+	</rdf:Description></prf:component>
+</rdf:Description>
+</rdf:RDF>`
+
+func TestParseUAProf(t *testing.T) {
+	prof, err := ParseUAProf(strings.NewReader(testUAProf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prof.ScreenWidth != 176 || prof.ScreenHeight != 208 {
+		t.Errorf("screen = %dx%d, want 176x208", prof.ScreenWidth, prof.ScreenHeight)
+	}
+	if prof.ColorCapable == nil || !*prof.ColorCapable {
+		t.Errorf("ColorCapable = %v, want yes", prof.ColorCapable)
+	}
+	if prof.WmlDeckSize != 20000 {
+		t.Errorf("WmlDeckSize = %d, want 20000", prof.WmlDeckSize)
+	}
+	if strings.Join(prof.Accept, ",") != "image/png,application/vnd.wap.wmlc" {
+		t.Errorf("Accept = %v", prof.Accept)
+	}
+	if strings.Join(prof.Charsets, ",") != "utf-8,iso-8859-1" {
+		t.Errorf("Charsets = %v", prof.Charsets)
+	}
+	if strings.Join(prof.WMLVersions, ",") != "1.1,1.2.1,2.0" {
+		t.Errorf("WMLVersions = %v", prof.WMLVersions)
+	}
+
+	p := Default
+	prof.apply(&p)
+	if p.ScreenWidth != 176 || !p.Color || !p.PNG || !p.WMLC || p.MaxDeckSize != 20000 {
+		t.Errorf("apply = %+v", p)
+	}
+	// WML 2.0 is XHTML, we render at most 1.3
+	if p.WMLVersion != "1.3" {
+		t.Errorf("apply WMLVersion = %s, want 1.3", p.WMLVersion)
+	}
+
+	if _, err := ParseUAProf(strings.NewReader("<rdf:RDF><unclosed")); err == nil {
+		t.Error("ParseUAProf of a truncated document did not fail")
+	}
+}
+
+func TestClampWMLVersion(t *testing.T) {
+	tests := map[string]string{
+		"1.1":     "1.1",
+		"1.2":     "1.2",
+		" 1.3 ":   "1.3",
+		"WML/1.2": "1.2",
+		"1.0":     "1.1",
+		"2.0":     "1.3",
+		"1.10":    "1.3",
+		"1.2.1":   "1.2",
+		"1":       "",
+		"":        "",
+		"x.y":     "",
+	}
+	for in, want := range tests {
+		if got := clampWMLVersion(in); got != want {
+			t.Errorf("clampWMLVersion(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDetectorLoad(t *testing.T) {
+	d, err := NewDetector(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const profileURL = "http://example.com/p.xml"
+	if err := os.WriteFile(d.path(profileURL), []byte(testUAProf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prof := d.uaprof(profileURL)
+	if prof == nil || prof.ScreenWidth != 176 {
+		t.Fatalf("uaprof = %+v, want the stored document", prof)
+	}
+	if d.uaprof(profileURL) != prof {
+		t.Error("second lookup did not come from memory")
+	}
+
+	// a broken document is removed so it is downloaded again
+	const brokenURL = "http://example.com/broken.xml"
+	if err := os.WriteFile(d.path(brokenURL), []byte("<rdf:RDF><unclosed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.pending[brokenURL] = true // keep the download from starting
+	if prof := d.uaprof(brokenURL); prof != nil {
+		t.Errorf("uaprof of a broken document = %+v", prof)
+	}
+	if _, err := os.Stat(d.path(brokenURL)); !os.IsNotExist(err) {
+		t.Error("broken document was kept")
+	}
+}
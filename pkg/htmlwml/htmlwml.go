@@ -0,0 +1,310 @@
+// Package htmlwml turns the HTML found in feeds into WML paragraphs
+package htmlwml
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type Options struct {
+	// Base resolves relative links and images, usually the article URL
+	Base string
+	// Image rewrites an absolute image URL into one the phone can show,
+	// images are dropped when it is nil or returns ""
+	Image func(src string) string
+	// Link rewrites an absolute link, links are kept as is when it is nil
+	// and reduced to their text when it returns ""
+	Link func(href string) string
+}
+
+var (
+	// dropped is markup whose content is never shown
+	dropped = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+		atom.Object: true, atom.Embed: true, atom.Video: true, atom.Audio: true,
+		atom.Svg: true, atom.Template: true, atom.Form: true, atom.Button: true,
+		atom.Select: true, atom.Textarea: true, atom.Input: true, atom.Head: true,
+	}
+
+	// blocks start a new paragraph
+	blocks = map[atom.Atom]bool{
+		atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+		atom.Blockquote: true, atom.Pre: true, atom.Figure: true, atom.Figcaption: true,
+		atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+		atom.Ul: true, atom.Ol: true, atom.Dl: true, atom.Table: true, atom.Tr: true,
+		atom.Hr: true, atom.Header: true, atom.Footer: true, atom.Main: true, atom.Aside: true,
+	}
+
+	// emphasis maps HTML text styles onto the WML ones
+	emphasis = map[atom.Atom]string{
+		atom.B: "b", atom.Strong: "b", atom.I: "i", atom.Em: "i", atom.Cite: "i",
+		atom.U: "u", atom.Ins: "u", atom.Small: "small", atom.Big: "big",
+	}
+
+	headings = map[atom.Atom]bool{
+		atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	}
+)
+
+// Convert returns the paragraphs of an HTML fragment as WML markup to put
+// inside <p> elements. Text is escaped for WML, elements WML lacks are
+// reduced to their text and empty paragraphs are left out.
+func Convert(fragment string, opts Options) ([]string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &converter{opts: opts}
+	c.base, _ = url.Parse(opts.Base)
+	for _, n := range nodes {
+		c.node(n)
+	}
+	c.flush()
+	return c.paragraphs, nil
+}
+
+type converter struct {
+	opts Options
+	base *url.URL
+
+	paragraphs []string
+	current    strings.Builder
+	// content is set once the current paragraph has something visible
+	content bool
+	// space is a pending space between words
+	space bool
+	// open are the emphasis elements open in the current paragraph
+	open []string
+	// inLink is set inside <a>, WML only allows text and images there
+	inLink bool
+}
+
+// flush ends the current paragraph, emphasis that is still open carries
+// over into the next one
+func (c *converter) flush() {
+	for i := len(c.open) - 1; i >= 0; i-- {
+		c.current.WriteString("</" + c.open[i] + ">")
+	}
+	if c.content {
+		c.paragraphs = append(c.paragraphs, strings.TrimSpace(c.current.String()))
+	}
+	c.current.Reset()
+	c.content, c.space = false, false
+	for _, tag := range c.open {
+		c.current.WriteString("<" + tag + ">")
+	}
+}
+
+func (c *converter) text(s string) {
+	if s == "" {
+		return
+	}
+	if isSpace(s[0]) {
+		c.space = true
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return
+	}
+	for i, w := range words {
+		if i > 0 || c.space {
+			c.writeSpace()
+		}
+		c.current.WriteString(render.Escape(w))
+		c.content = true
+	}
+	c.space = isSpace(s[len(s)-1])
+}
+
+func (c *converter) writeSpace() {
+	if c.content {
+		c.current.WriteByte(' ')
+	}
+	c.space = false
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func (c *converter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(child)
+	}
+}
+
+func (c *converter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+	if dropped[n.DataAtom] {
+		return
+	}
+
+	switch {
+	case n.DataAtom == atom.Br:
+		if c.content {
+			c.current.WriteString("<br/>")
+		}
+		c.space = false
+	case n.DataAtom == atom.Img:
+		c.image(n)
+	case n.DataAtom == atom.A:
+		c.link(n)
+	case c.inLink:
+		// a link cannot span paragraphs, keep only the text of anything in it
+		c.space = true
+		c.children(n)
+	case n.DataAtom == atom.Ul || n.DataAtom == atom.Ol:
+		c.list(n)
+	case headings[n.DataAtom]:
+		c.flush()
+		c.emphasis("b", n)
+		c.flush()
+	case blocks[n.DataAtom]:
+		c.flush()
+		c.children(n)
+		c.flush()
+	case emphasis[n.DataAtom] != "":
+		c.emphasis(emphasis[n.DataAtom], n)
+	default:
+		// td, span, font and everything else we do not know keep only their text
+		if n.DataAtom == atom.Td || n.DataAtom == atom.Th || n.DataAtom == atom.Li || n.DataAtom == atom.Dd {
+			c.space = true
+		}
+		c.children(n)
+	}
+}
+
+func (c *converter) emphasis(tag string, n *html.Node) {
+	if c.space {
+		c.writeSpace()
+	}
+	c.current.WriteString("<" + tag + ">")
+	c.open = append(c.open, tag)
+	c.children(n)
+	c.open = c.open[:len(c.open)-1]
+	c.current.WriteString("</" + tag + ">")
+}
+
+// list puts every item on its own line, numbered for <ol>
+func (c *converter) list(n *html.Node) {
+	c.flush()
+	number := 0
+	for item := n.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != html.ElementNode || item.DataAtom != atom.Li {
+			continue
+		}
+		if c.content {
+			c.current.WriteString("<br/>")
+		}
+		number++
+		if n.DataAtom == atom.Ol {
+			c.current.WriteString(strconv.Itoa(number) + ". ")
+		} else {
+			c.current.WriteString("- ")
+		}
+		c.content = true
+		c.space = false
+		c.children(item)
+	}
+	c.flush()
+}
+
+func (c *converter) image(n *html.Node) {
+	if c.opts.Image == nil {
+		return
+	}
+	src := c.resolve(attr(n, "src"))
+	if src == "" {
+		return
+	}
+	src = c.opts.Image(src)
+	if src == "" {
+		return
+	}
+	alt := strings.Join(strings.Fields(attr(n, "alt")), " ")
+	if alt == "" {
+		alt = "Image"
+	}
+
+	if c.space {
+		c.writeSpace()
+	}
+	c.current.WriteString(`<img src="` + render.Escape(src) + `" alt="` + render.Escape(alt) + `"/>`)
+	c.content = true
+}
+
+func (c *converter) link(n *html.Node) {
+	href := c.resolve(attr(n, "href"))
+	if href != "" && c.opts.Link != nil {
+		href = c.opts.Link(href)
+	}
+	if c.inLink || href == "" {
+		c.children(n)
+		return
+	}
+
+	if c.space {
+		c.writeSpace()
+	}
+	// emphasis is not allowed inside a link, close it around the link
+	for i := len(c.open) - 1; i >= 0; i-- {
+		c.current.WriteString("</" + c.open[i] + ">")
+	}
+	c.current.WriteString(`<a href="` + render.Escape(href) + `">`)
+	start := c.current.Len()
+	c.inLink = true
+	c.children(n)
+	c.inLink = false
+	if c.current.Len() == start {
+		// a link without text still needs something to select
+		c.current.WriteString(render.Escape(href))
+	}
+	c.current.WriteString("</a>")
+	c.content = true
+	for _, tag := range c.open {
+		c.current.WriteString("<" + tag + ">")
+	}
+}
+
+// resolve makes a link or image URL absolute, anything but http(s) is dropped
+func (c *converter) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if c.base != nil {
+		u = c.base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
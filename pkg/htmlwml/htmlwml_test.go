@@ -0,0 +1,100 @@
+package htmlwml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+)
+
+var testOptions = Options{
+	Base:  "https://example.com/news/article.html",
+	Image: func(src string) string { return "/png-convert.wbmp?url=" + src },
+	Link:  func(href string) string { return "/link?to=" + href },
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []string
+	}{
+		{"text", "Hello   <span>world</span>", []string{"Hello world"}},
+		{"paragraphs", "<p>One</p><div>Two</div><p> </p>", []string{"One", "Two"}},
+		{"escaping", "Fish &amp; chips &lt;3 for $5", []string{"Fish &amp; chips &lt;3 for $$5"}},
+		{"accents", "<p>Noël à Liège, ça va ?</p>", []string{"Noël à Liège, ça va ?"}},
+		{"emphasis", "<strong>Bold</strong> <em>and</em> <u>under</u>", []string{"<b>Bold</b> <i>and</i> <u>under</u>"}},
+		{"heading", "<h2>Title</h2>Body", []string{"<b>Title</b>", "Body"}},
+		{"line break", "one<br>two<br/>", []string{"one<br/>two<br/>"}},
+		{"lists", "<ul><li>a</li><li>b</li></ul><ol><li>x</li><li>y</li></ol>", []string{"- a<br/>- b", "1. x<br/>2. y"}},
+		{"dropped", "<script>alert(1)</script><style>p{}</style>Visible<iframe src=x></iframe>", []string{"Visible"}},
+		{"link", `See <a href="/more">more</a>`, []string{`See <a href="/link?to=https://example.com/more">more</a>`}},
+		{"javascript link", `<a href="javascript:alert(1)">text</a>`, []string{"text"}},
+		{"image", `<img src="pic.png" alt="A  cat">`, []string{`<img src="/png-convert.wbmp?url=https://example.com/news/pic.png" alt="A cat"/>`}},
+		{"emphasis around link", `<b>bold <a href="/x">link</a> end</b>`, []string{`<b>bold </b><a href="/link?to=https://example.com/x">link</a><b> end</b>`}},
+		{"emphasis over paragraphs", "<b>one<p>two</p></b>", []string{"<b>one</b>", "<b>two</b>"}},
+		{"empty", "<p><span> </span></p>", nil},
+	}
+	for _, tt := range tests {
+		got, err := Convert(tt.html, testOptions)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Convert(%q)\ngot  %q\nwant %q", tt.name, tt.html, got, tt.want)
+		}
+	}
+}
+
+// validDeck puts paragraphs in a WML 1.1 card and returns what the validator
+// has to say about it
+func validDeck(t *testing.T, paragraphs []string) []wml.Violation {
+	t.Helper()
+	deck := &strings.Builder{}
+	deck.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.1//EN" "http://www.wapforum.org/DTD/wml_1.1.xml">
+<wml><card id="article" title="Article">`)
+	for _, p := range paragraphs {
+		deck.WriteString("<p>" + p + "</p>")
+	}
+	deck.WriteString("</card></wml>")
+
+	doc, err := wml.Parse(strings.NewReader(deck.String()))
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, deck)
+	}
+	return wml.Validate(doc)
+}
+
+func TestConvertValid(t *testing.T) {
+	fragments := []string{
+		`<p>Plain <b>bold <i>nested <a href="https://example.org/">link <b>in</b> bold</a></i></b> tail</p>`,
+		`<table><tr><td>cell</td><td><img src="/a.png"></td></tr></table>`,
+		`<ul><li><a href="x">one</a></li><li><h3>heading in item</h3></li></ul>`,
+		`<a href="/outer"><p>block in link</p><img src="b.jpg"><a href="/inner">inner</a></a>`,
+		`<figure><img src="c.gif" alt=""><figcaption>Caption &copy; 2024</figcaption></figure>`,
+		`<blockquote><p>"Quote" &mdash; it's <font color=red>red</font></p></blockquote>`,
+		`<b>unclosed <i>emphasis<p>across</p>paragraphs`,
+		`<div><div><p>deep</p></div>text after<br><br></div>`,
+		`<pre>  code  block </pre><hr><small>small</small><big>big</big><sup>2</sup>`,
+		`<a href="mailto:me@example.com">mail</a> <a>no href</a> <img>`,
+		`Dollar $x and $(var) &amp; &#x1F600; emoji`,
+		`<form><input name=q></form><select><option>x</select><button>b</button>Left`,
+	}
+	for _, html := range fragments {
+		for _, opts := range []Options{testOptions, {Base: testOptions.Base}} {
+			paragraphs, err := Convert(html, opts)
+			if err != nil {
+				t.Fatalf("Convert(%q): %v", html, err)
+			}
+			if len(paragraphs) == 0 {
+				t.Errorf("Convert(%q) is empty", html)
+				continue
+			}
+			for _, v := range validDeck(t, paragraphs) {
+				t.Errorf("Convert(%q) = %q: %s", html, paragraphs, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Algorithm selects how grayscale images are reduced to black and white
+type Algorithm string
+
+const (
+	FloydSteinberg Algorithm = "fs"
+	Atkinson       Algorithm = "atkinson"
+	Bayer2         Algorithm = "bayer2"
+	Bayer4         Algorithm = "bayer4"
+	Bayer8         Algorithm = "bayer8"
+	Threshold      Algorithm = "threshold"
+)
+
+// ParseAlgorithm returns the Algorithm named s, it reports false for unknown names
+func ParseAlgorithm(s string) (Algorithm, bool) {
+	switch a := Algorithm(s); a {
+	case FloydSteinberg, Atkinson, Bayer2, Bayer4, Bayer8, Threshold:
+		return a, true
+	}
+	return "", false
+}
+
+// DitherOptions controls the conversion to black and white
+type DitherOptions struct {
+	Algorithm Algorithm
+	// Level is the threshold used by the Threshold algorithm, 0 picks one with Otsu's method
+	Level uint8
+	// Contrast stretches the grayscale values around the middle, 0 or 1 leaves them untouched
+	Contrast float64
+	// Gamma brightens (>1) or darkens (<1) the mid tones, 0 or 1 leaves them untouched
+	Gamma float64
+}
+
+// DefaultDitherOptions is what we used to get from ImageMagick
+var DefaultDitherOptions = DitherOptions{Algorithm: FloydSteinberg}
+
+// MaxAdjust is the largest contrast or gamma, beyond it images turn all black or white
+const MaxAdjust = 4
+
+// Normalize rounds contrast and gamma to one decimal between 0 and MaxAdjust,
+// options that look the same end up equal
+func (o DitherOptions) Normalize() DitherOptions {
+	o.Contrast = normalizeAdjust(o.Contrast)
+	o.Gamma = normalizeAdjust(o.Gamma)
+	return o
+}
+
+func normalizeAdjust(v float64) float64 {
+	// NaN fails every comparison
+	if !(v > 0) {
+		return 0
+	}
+	return math.Round(min(v, MaxAdjust)*10) / 10
+}
+
+// Dither converts img to black and white, in the result 0 is black and 255 is white
+func Dither(img image.Image, opts DitherOptions) *image.Gray {
+	gray := toGray(img)
+	adjust(gray, opts.Contrast, opts.Gamma)
+
+	switch opts.Algorithm {
+	case Atkinson:
+		diffuse(gray, atkinsonKernel)
+	case Bayer2:
+		ordered(gray, bayer(1))
+	case Bayer4:
+		ordered(gray, bayer(2))
+	case Bayer8:
+		ordered(gray, bayer(3))
+	case Threshold:
+		level := opts.Level
+		if level == 0 {
+			level = otsu(gray)
+		}
+		threshold(gray, level)
+	default:
+		diffuse(gray, floydSteinbergKernel)
+	}
+
+	return gray
+}
+
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			// composite transparent pixels onto white, most logos are transparent PNGs
+			lum := (19595*r + 38470*g + 7471*bl + 1<<15) >> 16
+			lum = lum + (0xffff - a)
+			if lum > 0xffff {
+				lum = 0xffff
+			}
+			gray.Pix[(y-b.Min.Y)*gray.Stride+(x-b.Min.X)] = uint8(lum >> 8)
+		}
+	}
+	return gray
+}
+
+func adjust(gray *image.Gray, contrast, gamma float64) {
+	if (contrast == 0 || contrast == 1) && (gamma == 0 || gamma == 1) {
+		return
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := float64(i) / 255
+		if contrast > 0 {
+			v = (v-0.5)*contrast + 0.5
+		}
+		v = math.Max(0, math.Min(1, v))
+		if gamma > 0 {
+			v = math.Pow(v, 1/gamma)
+		}
+		lut[i] = uint8(math.Round(v * 255))
+	}
+
+	for i, p := range gray.Pix {
+		gray.Pix[i] = lut[p]
+	}
+}
+
+type kernelEntry struct {
+	dx, dy int
+	weight float32
+}
+
+var floydSteinbergKernel = []kernelEntry{
+	{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+}
+
+// Atkinson only diffuses 6/8 of the error, keeping highlights and shadows clean
+var atkinsonKernel = []kernelEntry{
+	{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+	{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+	{0, 2, 1.0 / 8},
+}
+
+func diffuse(gray *image.Gray, kernel []kernelEntry) {
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	buf := make([]float32, w*h)
+	for y := range h {
+		for x := range w {
+			buf[y*w+x] = float32(gray.Pix[y*gray.Stride+x])
+		}
+	}
+
+	for y := range h {
+		for x := range w {
+			old := buf[y*w+x]
+			var v float32
+			if old >= 128 {
+				v = 255
+			}
+			gray.Pix[y*gray.Stride+x] = uint8(v)
+
+			e := old - v
+			for _, k := range kernel {
+				nx, ny := x+k.dx, y+k.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				buf[ny*w+nx] += e * k.weight
+			}
+		}
+	}
+}
+
+// bayer returns the 2^n x 2^n ordered dithering matrix scaled to 0-255
+func bayer(n int) [][]uint8 {
+	m := [][]int{{0}}
+	for range n {
+		size := len(m)
+		next := make([][]int, size*2)
+		for y := range next {
+			next[y] = make([]int, size*2)
+		}
+		for y := range size {
+			for x := range size {
+				v := m[y][x] * 4
+				next[y][x] = v
+				next[y][x+size] = v + 2
+				next[y+size][x] = v + 3
+				next[y+size][x+size] = v + 1
+			}
+		}
+		m = next
+	}
+
+	cells := len(m) * len(m)
+	out := make([][]uint8, len(m))
+	for y := range m {
+		out[y] = make([]uint8, len(m))
+		for x := range m[y] {
+			out[y][x] = uint8((2*m[y][x] + 1) * 255 / (2 * cells))
+		}
+	}
+	return out
+}
+
+func ordered(gray *image.Gray, matrix [][]uint8) {
+	size := len(matrix)
+	for y := range gray.Rect.Dy() {
+		for x := range gray.Rect.Dx() {
+			i := y*gray.Stride + x
+			if gray.Pix[i] > matrix[y%size][x%size] {
+				gray.Pix[i] = 255
+			} else {
+				gray.Pix[i] = 0
+			}
+		}
+	}
+}
+
+func threshold(gray *image.Gray, level uint8) {
+	for i, p := range gray.Pix {
+		if p >= level {
+			gray.Pix[i] = 255
+		} else {
+			gray.Pix[i] = 0
+		}
+	}
+}
+
+// otsu picks the threshold that best separates the histogram in two classes
+func otsu(gray *image.Gray) uint8 {
+	var hist [256]int
+	for _, p := range gray.Pix {
+		hist[p]++
+	}
+
+	total := len(gray.Pix)
+	sum := 0
+	for i, n := range hist {
+		sum += i * n
+	}
+
+	var best float64
+	level := 128
+	sumB, weightB := 0, 0
+	for i, n := range hist {
+		weightB += n
+		if weightB == 0 {
+			continue
+		}
+		weightF := total - weightB
+		if weightF == 0 {
+			break
+		}
+		sumB += i * n
+		meanB := float64(sumB) / float64(weightB)
+		meanF := float64(sum-sumB) / float64(weightF)
+		between := float64(weightB) * float64(weightF) * (meanB - meanF) * (meanB - meanF)
+		if between > best {
+			best = between
+			level = i + 1
+		}
+	}
+
+	if level > 255 {
+		level = 255
+	}
+	return uint8(level)
+}
+
+// String returns a stable description of the options, usable as a cache key
+func (o DitherOptions) String() string {
+	return fmt.Sprintf("%s:%d:%g:%g", o.Algorithm, o.Level, o.Contrast, o.Gamma)
+}
@@ -0,0 +1,87 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// gradient returns an 8x4 image going from black on the left to white on the right
+func gradient() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	for y := range 4 {
+		for x := range 8 {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 255 / 7)})
+		}
+	}
+	return img
+}
+
+// picture draws a black and white image as rows of . and #, ? marks gray
+func picture(img *image.Gray) string {
+	rows := []string{}
+	for y := range img.Rect.Dy() {
+		row := ""
+		for x := range img.Rect.Dx() {
+			switch img.GrayAt(x, y).Y {
+			case 0:
+				row += "."
+			case 255:
+				row += "#"
+			default:
+				row += "?"
+			}
+		}
+		rows = append(rows, row)
+	}
+	return strings.Join(rows, " ")
+}
+
+func TestDither(t *testing.T) {
+	tests := []struct {
+		opts DitherOptions
+		want string
+	}{
+		{DitherOptions{Algorithm: FloydSteinberg}, "...#.### ...#.### ...#.### ..#.##.#"},
+		{DitherOptions{Algorithm: Atkinson}, "....#### ...##.## ...#.### ....####"},
+		{DitherOptions{Algorithm: Bayer2}, "..#.#### ...#.#.# ..#.#### ...#.#.#"},
+		{DitherOptions{Algorithm: Bayer4}, "..#.#### ...#.#.# ..#.#.## ...#.###"},
+		{DitherOptions{Algorithm: Bayer8}, "..#.#### ...#.#.# ..#.#.## ...#.###"},
+		{DitherOptions{Algorithm: Threshold}, "....#### ....#### ....#### ....####"},
+		{DitherOptions{Algorithm: Threshold, Level: 200}, "......## ......## ......## ......##"},
+		{DitherOptions{Algorithm: Threshold, Level: 128, Gamma: 2}, "..###### ..###### ..###### ..######"},
+		{DitherOptions{Algorithm: Threshold, Level: 128, Contrast: 3}, "....#### ....#### ....#### ....####"},
+		{DitherOptions{}, "...#.### ...#.### ...#.### ..#.##.#"},
+	}
+	for _, tt := range tests {
+		src := gradient()
+		if got := picture(Dither(src, tt.opts)); got != tt.want {
+			t.Errorf("%+v: got  %s\nwant %s", tt.opts, got, tt.want)
+		}
+		if src.GrayAt(1, 0).Y != 36 {
+			t.Errorf("%+v: the source image was modified", tt.opts)
+		}
+	}
+}
+
+func TestDitherTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(10, 10, 12, 11))
+	img.SetNRGBA(10, 10, color.NRGBA{A: 0})
+	img.SetNRGBA(11, 10, color.NRGBA{A: 255})
+
+	if got := picture(Dither(img, DitherOptions{Algorithm: Threshold, Level: 128})); got != "#." {
+		t.Errorf("got %s, want transparent white and opaque black", got)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, name := range []string{"fs", "atkinson", "bayer2", "bayer4", "bayer8", "threshold"} {
+		if a, ok := ParseAlgorithm(name); !ok || string(a) != name {
+			t.Errorf("ParseAlgorithm(%q) = %q, %v", name, a, ok)
+		}
+	}
+	if a, ok := ParseAlgorithm("magick"); ok {
+		t.Errorf("ParseAlgorithm(magick) = %q, %v", a, ok)
+	}
+}
@@ -1,63 +1,96 @@
 package image
 
 import (
-	"fmt"
-	"os"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
 
-	"gopkg.in/gographics/imagick.v3/imagick"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wbmp"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 )
 
-func ImageToWBMP(input []byte, size int64) []byte {
-	imagick.Initialize()
-	defer imagick.Terminate()
+// MaxPixels is the largest source image we are willing to decode,
+// a small PNG can easily claim to be gigapixels in its header
+const MaxPixels = 4096 * 4096
 
-	tmpdir, _ := os.MkdirTemp("", "imagick")
-	defer os.RemoveAll(tmpdir)
+var ErrTooManyPixels = errors.New("image: source image has too many pixels")
 
-	// write the image to a file
-	err := os.WriteFile(tmpdir+"/image.png", input, 0644)
+func decode(input []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(input))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(input))
+	return img, err
+}
+
+// resize scales the image to size pixels wide, keeping the aspect ratio
+func resize(img image.Image, size int64) image.Image {
+	b := img.Bounds()
+	height := int(int64(b.Dy()) * size / int64(b.Dx()))
+	if height < 1 {
+		height = 1
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, int(size), height))
+	draw.CatmullRom.Scale(out, out.Bounds(), img, b, draw.Src, nil)
+	return out
+}
 
-	_, err = imagick.ConvertImageCommand([]string{"convert", tmpdir + "/image.png", "-resize", fmt.Sprintf("%d", size), "-dither", "FloydSteinberg", "-remap", "pattern:gray50", tmpdir + "/output.bmp"})
+func ImageToWBMP(input []byte, size int64, opts DitherOptions) ([]byte, error) {
+	img, err := decode(input)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	imagick.ConvertImageCommand([]string{"convert", tmpdir + "/output.bmp", "-resize", fmt.Sprintf("%d", size), tmpdir + "/output.wbmp"})
 
-	// read the image from the file
-	output, err := os.ReadFile(tmpdir + "/output.wbmp")
+	output := bytes.NewBuffer([]byte{})
+	err = wbmp.Encode(output, Dither(resize(img, size), opts))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return output
+	return output.Bytes(), nil
 }
 
-func ImageToJPEG(input []byte, size int64) []byte {
-	imagick.Initialize()
-	defer imagick.Terminate()
-
-	tmpdir, _ := os.MkdirTemp("", "imagick")
-	defer os.RemoveAll(tmpdir)
-
-	// write the image to a file
-	err := os.WriteFile(tmpdir+"/image.png", input, 0644)
+func ImageToJPEG(input []byte, size int64) ([]byte, error) {
+	img, err := decode(input)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	_, err = imagick.ConvertImageCommand([]string{"convert", tmpdir + "/image.png", "-resize", fmt.Sprintf("%d", size), "-quality", "15%", tmpdir + "/output.jpeg"})
+	output := bytes.NewBuffer([]byte{})
+	err = jpeg.Encode(output, resize(img, size), &jpeg.Options{Quality: 15})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// read the image from the file
-	output, err := os.ReadFile(tmpdir + "/output.jpeg")
-	if err != nil {
-		panic(err)
+	return output.Bytes(), nil
+}
+
+// Placeholder returns a size by size WBMP of a crossed out box, shown in place of images we could not convert
+func Placeholder(size int) []byte {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			if x == 0 || y == 0 || x == size-1 || y == size-1 || x == y || x == size-1-y {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
 	}
 
-	return output
+	output := bytes.NewBuffer([]byte{})
+	wbmp.Encode(output, img)
+	return output.Bytes()
 }
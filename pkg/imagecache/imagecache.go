@@ -0,0 +1,281 @@
+// Package imagecache keeps converted images in memory and on disk,
+// addressed by a hash of everything that went into the conversion
+package imagecache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Key describes a conversion, two equal keys produce the same image
+type Key struct {
+	URL    string
+	Format string
+	Size   int64
+	Dither string
+}
+
+// Hash returns the content address of the key
+func (k Key) Hash() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%d\x00%s", k.URL, k.Format, k.Size, k.Dither))
+	return hex.EncodeToString(sum[:])
+}
+
+// ETag returns the entity tag for the converted image
+func (k Key) ETag() string {
+	return `"` + k.Hash()[:16] + `"`
+}
+
+type Entry struct {
+	Data     []byte
+	Modified time.Time
+}
+
+type memoryEntry struct {
+	hash  string
+	entry Entry
+}
+
+// Cache is a size bounded LRU in memory in front of a directory on disk,
+// entries older than maxAge are treated as missing so changed images get picked up.
+// The directory is kept under maxDiskBytes by a sweeper deleting the oldest files
+type Cache struct {
+	dir          string
+	maxAge       time.Duration
+	maxBytes     int
+	maxDiskBytes int64
+
+	lock   sync.Mutex
+	bytes  int
+	lru    *list.List
+	byHash map[string]*list.Element
+
+	// diskBytes is what the directory held at the last sweep plus what was written since
+	diskBytes atomic.Int64
+	// full wakes the sweeper before its interval when diskBytes passes maxDiskBytes
+	full chan struct{}
+}
+
+// New creates a cache storing files in dir, an empty dir keeps the cache in memory only
+func New(dir string, maxBytes int, maxDiskBytes int64, maxAge time.Duration) (*Cache, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Cache{
+		dir:          dir,
+		maxAge:       maxAge,
+		maxBytes:     maxBytes,
+		maxDiskBytes: maxDiskBytes,
+		lru:          list.New(),
+		byHash:       map[string]*list.Element{},
+		full:         make(chan struct{}, 1),
+	}, nil
+}
+
+func (c *Cache) path(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+func (c *Cache) Get(key Key) (Entry, bool) {
+	hash := key.Hash()
+
+	c.lock.Lock()
+	if el, ok := c.byHash[hash]; ok {
+		entry := el.Value.(*memoryEntry).entry
+		if c.maxAge == 0 || time.Since(entry.Modified) < c.maxAge {
+			c.lru.MoveToFront(el)
+			c.lock.Unlock()
+			return entry, true
+		}
+		c.remove(el)
+	}
+	c.lock.Unlock()
+
+	if c.dir == "" {
+		return Entry{}, false
+	}
+
+	info, err := os.Stat(c.path(hash))
+	if err != nil || (c.maxAge > 0 && time.Since(info.ModTime()) >= c.maxAge) {
+		return Entry{}, false
+	}
+	data, err := os.ReadFile(c.path(hash))
+	if err != nil {
+		return Entry{}, false
+	}
+
+	entry := Entry{Data: data, Modified: info.ModTime()}
+	c.remember(hash, entry)
+	return entry, true
+}
+
+// Put stores data for key and returns the stored entry
+func (c *Cache) Put(key Key, data []byte) (Entry, error) {
+	hash := key.Hash()
+	entry := Entry{Data: data, Modified: time.Now().Truncate(time.Second)}
+	c.remember(hash, entry)
+
+	if c.dir == "" {
+		return entry, nil
+	}
+
+	p := c.path(hash)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return entry, err
+	}
+	// write to a temporary file first so readers never see half an image
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return entry, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return entry, err
+	}
+	if err := tmp.Close(); err != nil {
+		return entry, err
+	}
+	if err := os.Chtimes(tmp.Name(), entry.Modified, entry.Modified); err != nil {
+		return entry, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return entry, err
+	}
+
+	if c.diskBytes.Add(int64(len(data))) > c.maxDiskBytes {
+		select {
+		case c.full <- struct{}{}:
+		default:
+		}
+	}
+	return entry, nil
+}
+
+// tmpMaxAge is how long a temporary file may exist before we consider it
+// left over from a crash
+const tmpMaxAge = time.Minute
+
+type diskFile struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// Sweep deletes the files older than maxAge, then the oldest files until
+// the directory holds at most maxDiskBytes
+func (c *Cache) Sweep() error {
+	if c.dir == "" {
+		return nil
+	}
+
+	now := time.Now()
+	var files []diskFile
+	var total int64
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			// deleted while we were walking
+			return nil
+		}
+
+		age := now.Sub(info.ModTime())
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			if age > tmpMaxAge {
+				os.Remove(path)
+			}
+			return nil
+		}
+		if c.maxAge > 0 && age >= c.maxAge {
+			os.Remove(path)
+			return nil
+		}
+
+		files = append(files, diskFile{path: path, size: info.Size(), modified: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(files, func(a, b diskFile) int {
+		return a.modified.Compare(b.modified)
+	})
+	for _, f := range files {
+		if total <= c.maxDiskBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= f.size
+	}
+
+	c.diskBytes.Store(total)
+	return nil
+}
+
+// StartSweeper sweeps the cache right away, then every interval and whenever
+// the directory grew past its limit, until ctx is cancelled
+func StartSweeper(ctx context.Context, c *Cache, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := c.Sweep(); err != nil {
+				log.Println("image cache sweep failed:", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-c.full:
+			}
+		}
+	}()
+}
+
+func (c *Cache) remember(hash string, entry Entry) {
+	if len(entry.Data) > c.maxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.byHash[hash]; ok {
+		c.remove(el)
+	}
+	c.byHash[hash] = c.lru.PushFront(&memoryEntry{hash: hash, entry: entry})
+	c.bytes += len(entry.Data)
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*memoryEntry)
+	c.lru.Remove(el)
+	delete(c.byHash, e.hash)
+	c.bytes -= len(e.entry.Data)
+}
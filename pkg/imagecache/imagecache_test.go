@@ -0,0 +1,173 @@
+package imagecache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testKey(n int) Key {
+	return Key{URL: fmt.Sprintf("http://example.com/%d.png", n), Format: "wbmp", Size: 80, Dither: "fs"}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	c, err := New("", 30, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 {
+		if _, err := c.Put(testKey(i), bytes.Repeat([]byte{byte(i)}, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// reading 0 makes 1 the least recently used
+	if _, ok := c.Get(testKey(0)); !ok {
+		t.Fatal("entry 0 missing before the cache was full")
+	}
+	if _, err := c.Put(testKey(3), bytes.Repeat([]byte{3}, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false, true, true} {
+		if _, ok := c.Get(testKey(i)); ok != want {
+			t.Errorf("Get(%d) found = %v, want %v", i, ok, want)
+		}
+	}
+	if c.bytes > c.maxBytes {
+		t.Errorf("cache holds %d bytes, limit is %d", c.bytes, c.maxBytes)
+	}
+
+	// entries larger than the whole cache are not kept in memory
+	c.Put(testKey(4), make([]byte, 40))
+	if _, ok := c.Get(testKey(4)); ok {
+		t.Error("entry larger than the cache was kept")
+	}
+}
+
+func TestDiskFallback(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 0, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put, err := c.Put(testKey(0), []byte("image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a zero memory limit keeps nothing in memory, this is read from disk
+	got, ok := c.Get(testKey(0))
+	if !ok {
+		t.Fatal("entry missing from disk")
+	}
+	if string(got.Data) != "image" || !got.Modified.Equal(put.Modified) {
+		t.Errorf("Get = %q modified %v, want %q modified %v", got.Data, got.Modified, "image", put.Modified)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path(testKey(0).Hash()), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(testKey(0)); ok {
+		t.Error("entry older than maxAge was returned")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 0, 25, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	ages := []time.Duration{2 * time.Hour, 30 * time.Minute, 20 * time.Minute, 10 * time.Minute, time.Minute}
+	for i, age := range ages {
+		if _, err := c.Put(testKey(i), make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+		modified := now.Add(-age)
+		if err := os.Chtimes(c.path(testKey(i).Hash()), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a temporary file left over from a crash, and one that is still being written
+	stale := filepath.Join(dir, ".tmp-stale")
+	fresh := filepath.Join(dir, ".tmp-fresh")
+	for _, p := range []string{stale, fresh} {
+		if err := os.WriteFile(p, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(stale, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0 is past maxAge, then 1 and 2 are the oldest that do not fit 25 bytes
+	for i, want := range []bool{false, false, false, true, true} {
+		_, err := os.Stat(c.path(testKey(i).Hash()))
+		if exists := err == nil; exists != want {
+			t.Errorf("file %d exists = %v, want %v", i, exists, want)
+		}
+	}
+	if _, err := os.Stat(stale); err == nil {
+		t.Error("stale temporary file was kept")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("temporary file in use was deleted")
+	}
+	if got := c.diskBytes.Load(); got != 20 {
+		t.Errorf("diskBytes = %d after the sweep, want 20", got)
+	}
+}
+
+func TestPutWakesSweeper(t *testing.T) {
+	c, err := New(t.TempDir(), 0, 15, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put(testKey(0), make([]byte, 10))
+	select {
+	case <-c.full:
+		t.Fatal("sweeper woken while under the limit")
+	default:
+	}
+
+	c.Put(testKey(1), make([]byte, 10))
+	select {
+	case <-c.full:
+	default:
+		t.Fatal("sweeper not woken past the limit")
+	}
+}
+
+func TestETag(t *testing.T) {
+	a := testKey(0)
+	if a.ETag() != testKey(0).ETag() {
+		t.Error("equal keys have different ETags")
+	}
+
+	changes := []func(k *Key){
+		func(k *Key) { k.URL += "?x" },
+		func(k *Key) { k.Format = "jpeg" },
+		func(k *Key) { k.Size = 96 },
+		func(k *Key) { k.Dither = "atkinson" },
+	}
+	for i, change := range changes {
+		b := a
+		change(&b)
+		if b.ETag() == a.ETag() {
+			t.Errorf("change %d keeps the ETag %s", i, a.ETag())
+		}
+	}
+}
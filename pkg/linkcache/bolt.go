@@ -0,0 +1,140 @@
+package linkcache
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var linksBucket = []byte("links")
+
+// BoltStore is a LinkStore backed by a bbolt database file,
+// links survive restarts and expire between ttl/2 and ttl after they were last used
+type BoltStore struct {
+	db  *bolt.DB
+	ids *IDGenerator
+	ttl time.Duration
+}
+
+func NewBoltStore(path string, ids *IDGenerator, ttl time.Duration) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(linksBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db, ids: ids, ttl: ttl}, nil
+}
+
+// a link record is the expiry as unix seconds followed by the URL
+func encodeRecord(link string, expires time.Time) []byte {
+	b := make([]byte, 8+len(link))
+	binary.BigEndian.PutUint64(b, uint64(expires.Unix()))
+	copy(b[8:], link)
+	return b
+}
+
+func decodeRecord(b []byte) (string, time.Time) {
+	if len(b) < 8 {
+		return "", time.Time{}
+	}
+	return string(b[8:]), time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+}
+
+func (s *BoltStore) expired(expires, now time.Time) bool {
+	return s.ttl > 0 && now.After(expires)
+}
+
+func (s *BoltStore) Store(link string) (string, error) {
+	var id string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		links := tx.Bucket(linksBucket)
+		now := time.Now()
+
+		for attempt := range maxAttempts {
+			candidate := s.ids.ID(link, attempt)
+			if record := links.Get([]byte(candidate)); record != nil {
+				existing, expires := decodeRecord(record)
+				if existing != link && !s.expired(expires, now) {
+					continue
+				}
+			}
+
+			id = candidate
+			return links.Put([]byte(id), encodeRecord(link, now.Add(s.ttl)))
+		}
+
+		return ErrNoFreeID
+	})
+
+	return id, err
+}
+
+func (s *BoltStore) Get(id string) (string, error) {
+	var link string
+	var expires time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if record := tx.Bucket(linksBucket).Get([]byte(id)); record != nil {
+			link, expires = decodeRecord(record)
+		}
+		return nil
+	})
+	if err != nil || link == "" {
+		return "", err
+	}
+
+	now := time.Now()
+	if s.expired(expires, now) {
+		return "", nil
+	}
+
+	// most lookups stay read-only, a link is only extended once it used up
+	// half its ttl and concurrent extensions share one write transaction
+	if s.ttl > 0 && expires.Sub(now) < s.ttl/2 {
+		err = s.db.Batch(func(tx *bolt.Tx) error {
+			links := tx.Bucket(linksBucket)
+			// the link may have been swept or replaced in the meantime
+			if current, _ := decodeRecord(links.Get([]byte(id))); current != link {
+				return nil
+			}
+			return links.Put([]byte(id), encodeRecord(link, now.Add(s.ttl)))
+		})
+	}
+
+	return link, err
+}
+
+func (s *BoltStore) Sweep() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		links := tx.Bucket(linksBucket)
+		now := time.Now()
+
+		stale := [][]byte{}
+		c := links.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if _, expires := decodeRecord(v); s.expired(expires, now) {
+				stale = append(stale, append([]byte{}, k...))
+			}
+		}
+
+		for _, k := range stale {
+			if err := links.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
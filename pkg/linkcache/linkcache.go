@@ -0,0 +1,84 @@
+package linkcache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	idLength    = 8
+	maxAttempts = 16
+	base62      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// ErrNoFreeID is returned when every candidate ID for a link is taken by another link
+var ErrNoFreeID = errors.New("linkcache: no free ID for link")
+
+// ErrTooLarge is returned when a link does not fit the byte limit of a store
+var ErrTooLarge = errors.New("linkcache: link too large")
+
+// LinkStore maps short IDs to full URLs
+// this is so we can hand out cache:linkID and fetch the full URL later
+// this is done as the Nokia 7110 has a hard link length limit
+type LinkStore interface {
+	// Store returns the ID for link, creating a new one if it is not known yet
+	Store(link string) (string, error)
+	// Get returns the link for id, or an empty string if it is unknown or expired
+	Get(id string) (string, error)
+	// Sweep removes all expired links
+	Sweep() error
+	Close() error
+}
+
+// IDGenerator derives short IDs from a keyed hash of the link,
+// replicas sharing the key hand out the same ID for the same URL
+type IDGenerator struct {
+	key []byte
+}
+
+func NewIDGenerator(key []byte) *IDGenerator {
+	return &IDGenerator{key: key}
+}
+
+// ID returns the base62 ID for link, attempt is raised when
+// an earlier candidate collided with a different link
+func (g *IDGenerator) ID(link string, attempt int) string {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(link))
+	if attempt > 0 {
+		mac.Write([]byte{0})
+		mac.Write([]byte(strconv.Itoa(attempt)))
+	}
+	n := binary.BigEndian.Uint64(mac.Sum(nil))
+
+	id := make([]byte, idLength)
+	for i := range id {
+		id[i] = base62[n%62]
+		n /= 62
+	}
+	return string(id)
+}
+
+// StartSweeper evicts stale links from store every interval until ctx is cancelled
+func StartSweeper(ctx context.Context, store LinkStore, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := store.Sweep(); err != nil {
+					log.Println("link cache sweep failed:", err)
+				}
+			}
+		}
+	}()
+}
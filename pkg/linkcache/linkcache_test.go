@@ -0,0 +1,255 @@
+package linkcache
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var testKey = []byte("test key")
+
+func TestIDDeterministic(t *testing.T) {
+	a, b := NewIDGenerator(testKey), NewIDGenerator(testKey)
+	other := NewIDGenerator([]byte("other key"))
+
+	for _, link := range []string{"", "http://example.com/", "https://example.com/a?b=c&d=é"} {
+		id := a.ID(link, 0)
+		if len(id) != idLength || strings.Trim(id, base62) != "" {
+			t.Errorf("ID(%q) = %q is not %d base62 characters", link, id, idLength)
+		}
+		if got := b.ID(link, 0); got != id {
+			t.Errorf("ID(%q) = %q with the same key, want %q", link, got, id)
+		}
+		if got := other.ID(link, 0); got == id {
+			t.Errorf("ID(%q) = %q with another key too", link, got)
+		}
+
+		seen := map[string]bool{id: true}
+		for attempt := 1; attempt < maxAttempts; attempt++ {
+			next := a.ID(link, attempt)
+			if seen[next] {
+				t.Errorf("ID(%q, %d) = %q repeats an earlier attempt", link, attempt, next)
+			}
+			seen[next] = true
+		}
+	}
+}
+
+// testStores returns a fresh memory and bolt store using testKey
+func testStores(t *testing.T, ttl time.Duration) map[string]LinkStore {
+	t.Helper()
+	ids := NewIDGenerator(testKey)
+	b, err := NewBoltStore(filepath.Join(t.TempDir(), "links.db"), ids, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return map[string]LinkStore{
+		"memory": NewMemoryStore(ids, ttl, 1000, 0),
+		"bolt":   b,
+	}
+}
+
+// occupy stores link under id as if it had won that ID earlier
+func occupy(t *testing.T, store LinkStore, id, link string, expires time.Time) {
+	t.Helper()
+	switch s := store.(type) {
+	case *MemoryStore:
+		sh := s.shard(id)
+		sh.lock.Lock()
+		sh.byID[id] = sh.lru.PushFront(&memoryEntry{id: id, link: link, expires: expires})
+		sh.lock.Unlock()
+	case *BoltStore:
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(linksBucket).Put([]byte(id), encodeRecord(link, expires))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreSameID(t *testing.T) {
+	ids := NewIDGenerator(testKey)
+	for name, store := range testStores(t, time.Hour) {
+		link := "http://example.com/" + name
+		id, err := store.Store(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ids.ID(link, 0); id != want {
+			t.Errorf("%s: Store = %q, want %q", name, id, want)
+		}
+		if again, _ := store.Store(link); again != id {
+			t.Errorf("%s: storing again gave %q, want %q", name, again, id)
+		}
+		if got, err := store.Get(id); got != link || err != nil {
+			t.Errorf("%s: Get(%q) = %q, %v, want %q", name, id, got, err, link)
+		}
+		if got, err := store.Get("unknown"); got != "" || err != nil {
+			t.Errorf("%s: Get(unknown) = %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestStoreCollision(t *testing.T) {
+	ids := NewIDGenerator(testKey)
+	link := "http://example.com/wanted"
+	later := time.Now().Add(time.Hour)
+
+	for name, store := range testStores(t, time.Hour) {
+		// the first two candidates belong to other links
+		occupy(t, store, ids.ID(link, 0), "http://example.com/first", later)
+		occupy(t, store, ids.ID(link, 1), "http://example.com/second", later)
+
+		id, err := store.Store(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ids.ID(link, 2); id != want {
+			t.Errorf("%s: Store = %q, want the third candidate %q", name, id, want)
+		}
+		if again, _ := store.Store(link); again != id {
+			t.Errorf("%s: storing again gave %q, want %q", name, again, id)
+		}
+		if got, _ := store.Get(ids.ID(link, 0)); got != "http://example.com/first" {
+			t.Errorf("%s: the first candidate now holds %q", name, got)
+		}
+	}
+}
+
+func TestStoreExpiredCollision(t *testing.T) {
+	ids := NewIDGenerator(testKey)
+	link := "http://example.com/wanted"
+
+	for name, store := range testStores(t, time.Hour) {
+		occupy(t, store, ids.ID(link, 0), "http://example.com/old", time.Now().Add(-time.Hour))
+
+		id, err := store.Store(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ids.ID(link, 0); id != want {
+			t.Errorf("%s: Store = %q, want the expired candidate %q", name, id, want)
+		}
+	}
+}
+
+func TestStoreNoFreeID(t *testing.T) {
+	ids := NewIDGenerator(testKey)
+	link := "http://example.com/wanted"
+	later := time.Now().Add(time.Hour)
+
+	for name, store := range testStores(t, time.Hour) {
+		for attempt := range maxAttempts {
+			occupy(t, store, ids.ID(link, attempt), "http://example.com/other", later)
+		}
+		if id, err := store.Store(link); !errors.Is(err, ErrNoFreeID) {
+			t.Errorf("%s: Store = %q, %v, want ErrNoFreeID", name, id, err)
+		}
+	}
+}
+
+func TestSharedBoltStore(t *testing.T) {
+	// replicas sharing the key hand out the same ID without talking to each other
+	path := filepath.Join(t.TempDir(), "links.db")
+	link := "http://example.com/shared"
+
+	first, err := NewBoltStore(path, NewIDGenerator(testKey), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := first.Store(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second, err := NewBoltStore(path, NewIDGenerator(testKey), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if got, _ := second.Get(id); got != link {
+		t.Errorf("Get(%q) after reopening = %q, want %q", id, got, link)
+	}
+	if again, _ := second.Store(link); again != id {
+		t.Errorf("Store after reopening = %q, want %q", again, id)
+	}
+	if memory, _ := NewMemoryStore(NewIDGenerator(testKey), time.Hour, 100, 0).Store(link); memory != id {
+		t.Errorf("memory store gave %q, bolt %q", memory, id)
+	}
+}
+
+func TestMemoryStoreSmallLimit(t *testing.T) {
+	tests := []struct {
+		maxEntries, perShard int
+	}{
+		{0, 0},
+		{1, 1},
+		{15, 1},
+		{16, 1},
+		{17, 2},
+		{100000, 6250},
+	}
+	for _, tt := range tests {
+		m := NewMemoryStore(NewIDGenerator(testKey), time.Hour, tt.maxEntries, 0)
+		if m.maxShardEntries != tt.perShard {
+			t.Errorf("NewMemoryStore(%d) keeps %d links per shard, want %d", tt.maxEntries, m.maxShardEntries, tt.perShard)
+		}
+	}
+
+	// a limit below the number of shards still bounds the store
+	m := NewMemoryStore(NewIDGenerator(testKey), time.Hour, 1, 0)
+	for i := range 100 {
+		if _, err := m.Store(fmt.Sprintf("http://example.com/%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := 0
+	for _, s := range m.shards {
+		total += s.lru.Len()
+	}
+	if total == 0 || total > memoryShards {
+		t.Errorf("store holds %d links, want between 1 and %d", total, memoryShards)
+	}
+}
+
+func TestMemoryStoreByteLimit(t *testing.T) {
+	m := NewMemoryStore(NewIDGenerator(testKey), time.Hour, 0, memoryShards*100)
+
+	if _, err := m.Store(strings.Repeat("x", 101)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Store of a link larger than a shard = %v, want ErrTooLarge", err)
+	}
+
+	for i := range 200 {
+		if _, err := m.Store(fmt.Sprintf("%040d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, s := range m.shards {
+		if s.bytes > m.maxShardBytes {
+			t.Errorf("shard %d holds %d bytes, limit is %d", i, s.bytes, m.maxShardBytes)
+		}
+		n := 0
+		for el := s.lru.Front(); el != nil; el = el.Next() {
+			n += len(el.Value.(*memoryEntry).link)
+		}
+		if n != s.bytes {
+			t.Errorf("shard %d counts %d bytes, its links add up to %d", i, s.bytes, n)
+		}
+	}
+
+	// the most recent link is never the one evicted
+	last := fmt.Sprintf("%040d", 199)
+	id, _ := m.Store(last)
+	if got, _ := m.Get(id); got != last {
+		t.Errorf("Get(%q) = %q, want the last stored link", id, got)
+	}
+}
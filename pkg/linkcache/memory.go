@@ -0,0 +1,170 @@
+package linkcache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const memoryShards = 16
+
+type memoryEntry struct {
+	id      string
+	link    string
+	expires time.Time
+}
+
+type memoryShard struct {
+	lock  sync.Mutex
+	lru   *list.List
+	byID  map[string]*list.Element
+	bytes int
+}
+
+// MemoryStore is an in-memory LinkStore that keeps at most maxEntries links
+// of together at most maxBytes, both rounded up to a multiple of the shard
+// count. It evicts the least recently used link first and forgets links that
+// were not used for ttl. A limit of 0 means no limit
+type MemoryStore struct {
+	ttl             time.Duration
+	maxShardEntries int
+	maxShardBytes   int
+	ids             *IDGenerator
+	shards          [memoryShards]*memoryShard
+}
+
+func NewMemoryStore(ids *IDGenerator, ttl time.Duration, maxEntries, maxBytes int) *MemoryStore {
+	m := &MemoryStore{
+		ttl:             ttl,
+		maxShardEntries: perShard(maxEntries),
+		maxShardBytes:   perShard(maxBytes),
+		ids:             ids,
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			lru:  list.New(),
+			byID: map[string]*list.Element{},
+		}
+	}
+	return m
+}
+
+// perShard splits a limit for the whole store over the shards, rounding up
+// so a limit below memoryShards does not turn into 0, which means no limit
+func perShard(limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return (limit + memoryShards - 1) / memoryShards
+}
+
+func (m *MemoryStore) shard(id string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return m.shards[h.Sum32()%memoryShards]
+}
+
+func (m *MemoryStore) Store(link string) (string, error) {
+	// it would push everything else out of its shard and then itself
+	if m.maxShardBytes > 0 && len(link) > m.maxShardBytes {
+		return "", ErrTooLarge
+	}
+
+	now := time.Now()
+	for attempt := range maxAttempts {
+		id := m.ids.ID(link, attempt)
+		if m.claim(id, link, now) {
+			return id, nil
+		}
+	}
+
+	return "", ErrNoFreeID
+}
+
+// claim stores link under id, it reports false if id belongs to another link
+func (m *MemoryStore) claim(id, link string, now time.Time) bool {
+	s := m.shard(id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if el, exists := s.byID[id]; exists {
+		if m.expired(el, now) {
+			s.remove(el)
+		} else if el.Value.(*memoryEntry).link == link {
+			m.touch(s, el, now)
+			return true
+		} else {
+			return false
+		}
+	}
+
+	s.byID[id] = s.lru.PushFront(&memoryEntry{id: id, link: link, expires: now.Add(m.ttl)})
+	s.bytes += len(link)
+	for m.full(s) {
+		s.remove(s.lru.Back())
+	}
+	return true
+}
+
+func (m *MemoryStore) full(s *memoryShard) bool {
+	return m.maxShardEntries > 0 && s.lru.Len() > m.maxShardEntries ||
+		m.maxShardBytes > 0 && s.bytes > m.maxShardBytes
+}
+
+func (m *MemoryStore) Get(id string) (string, error) {
+	s := m.shard(id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	el, exists := s.byID[id]
+	if !exists {
+		return "", nil
+	}
+
+	now := time.Now()
+	if m.expired(el, now) {
+		s.remove(el)
+		return "", nil
+	}
+	m.touch(s, el, now)
+
+	return el.Value.(*memoryEntry).link, nil
+}
+
+func (m *MemoryStore) Sweep() error {
+	now := time.Now()
+	for _, s := range m.shards {
+		s.lock.Lock()
+		for el := s.lru.Back(); el != nil; {
+			prev := el.Prev()
+			if m.expired(el, now) {
+				s.remove(el)
+			}
+			el = prev
+		}
+		s.lock.Unlock()
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) expired(el *list.Element, now time.Time) bool {
+	return m.ttl > 0 && now.After(el.Value.(*memoryEntry).expires)
+}
+
+func (m *MemoryStore) touch(s *memoryShard, el *list.Element, now time.Time) {
+	el.Value.(*memoryEntry).expires = now.Add(m.ttl)
+	s.lru.MoveToFront(el)
+}
+
+func (s *memoryShard) remove(el *list.Element) {
+	e := el.Value.(*memoryEntry)
+	s.lru.Remove(el)
+	delete(s.byID, e.id)
+	s.bytes -= len(e.link)
+}
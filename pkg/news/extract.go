@@ -0,0 +1,244 @@
+package news
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ErrNoContent is returned for pages we could not find an article body in
+var ErrNoContent = errors.New("no article content found")
+
+// Block is one paragraph of an extracted article, either text or an image
+type Block struct {
+	Text    string `json:",omitempty"`
+	Heading bool   `json:",omitempty"`
+	Image   string `json:",omitempty"`
+}
+
+// minParagraph is the length a paragraph needs to count towards its parent
+const minParagraph = 25
+
+var (
+	// elements that never hold article text
+	boilerplate = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+		atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+		atom.Form: true, atom.Button: true, atom.Svg: true, atom.Template: true,
+		atom.Select: true, atom.Input: true, atom.Textarea: true, atom.Dialog: true,
+	}
+
+	positiveNames = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+	negativeNames = regexp.MustCompile(`(?i)ad-|advert|banner|breadcrumb|comment|cookie|footer|menu|meta|nav|newsletter|promo|related|share|sidebar|social|sponsor|subscribe|tags|widget`)
+)
+
+// Extract finds the article body of an HTML page the way readability does:
+// paragraphs score their parent, the best scoring container is the article
+// and its paragraphs and images are returned in order. Relative image URLs
+// are resolved against pageURL.
+func Extract(page []byte, pageURL string) ([]Block, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := map[*html.Node]float64{}
+	var candidates []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = nameWeight(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Blockquote {
+			return
+		}
+		text := textContent(n)
+		if len(text) < minParagraph {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		addScore(n.Parent, score)
+		if n.Parent != nil {
+			addScore(n.Parent.Parent, score/2)
+		}
+	})
+
+	var top *html.Node
+	best := 0.0
+	for _, n := range candidates {
+		score := scores[n] * (1 - linkDensity(n))
+		if score > best {
+			top, best = n, score
+		}
+	}
+	if top == nil {
+		return nil, ErrNoContent
+	}
+
+	blocks := convert(top, base)
+	if len(blocks) == 0 {
+		return nil, ErrNoContent
+	}
+	return blocks, nil
+}
+
+// walk calls fn for every node below n, skipping boilerplate elements
+func walk(n *html.Node, fn func(*html.Node)) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && boilerplate[c.DataAtom] {
+			continue
+		}
+		fn(c)
+		walk(c, fn)
+	}
+}
+
+// nameWeight rewards or penalises an element for its class and id
+func nameWeight(n *html.Node) float64 {
+	names := attr(n, "class") + " " + attr(n, "id")
+	weight := 0.0
+	if negativeNames.MatchString(names) {
+		weight -= 25
+	}
+	if positiveNames.MatchString(names) {
+		weight += 25
+	}
+	switch n.DataAtom {
+	case atom.Article, atom.Main:
+		weight += 10
+	case atom.Div:
+		weight += 5
+	}
+	return weight
+}
+
+// linkDensity is the share of the text of n that sits in links
+func linkDensity(n *html.Node) float64 {
+	text := len(textContent(n))
+	if text == 0 {
+		return 0
+	}
+	links := 0
+	walk(n, func(c *html.Node) {
+		if c.DataAtom == atom.A {
+			links += len(textContent(c))
+		}
+	})
+	return min(float64(links)/float64(text), 1)
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns the text below n with whitespace collapsed
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+			return
+		}
+		if n.DataAtom == atom.Br {
+			b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && boilerplate[c.DataAtom] {
+				continue
+			}
+			collect(c)
+		}
+	}
+	collect(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// convert flattens the article container into blocks, dropping the
+// link lists and share bars that sit inside most article bodies
+func convert(top *html.Node, base *url.URL) []Block {
+	var blocks []Block
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				if text := strings.Join(strings.Fields(c.Data), " "); c.Type == html.TextNode && len(text) >= minParagraph {
+					blocks = append(blocks, Block{Text: text})
+				}
+				continue
+			}
+			if boilerplate[c.DataAtom] || (c != top && nameWeight(c) < 0) {
+				continue
+			}
+
+			switch c.DataAtom {
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				if text := textContent(c); text != "" {
+					blocks = append(blocks, Block{Text: text, Heading: true})
+				}
+			case atom.P, atom.Li, atom.Blockquote, atom.Pre, atom.Figcaption:
+				// images inside a paragraph come before its text
+				walk(c, func(img *html.Node) {
+					if img.DataAtom == atom.Img {
+						if src := imageURL(img, base); src != "" {
+							blocks = append(blocks, Block{Image: src})
+						}
+					}
+				})
+				if text := textContent(c); text != "" && linkDensity(c) < 0.5 {
+					blocks = append(blocks, Block{Text: text})
+				}
+			case atom.Img:
+				if src := imageURL(c, base); src != "" {
+					blocks = append(blocks, Block{Image: src})
+				}
+			case atom.Ul, atom.Ol:
+				if linkDensity(c) < 0.5 {
+					visit(c)
+				}
+			default:
+				visit(c)
+			}
+		}
+	}
+	visit(top)
+	return blocks
+}
+
+// imageURL resolves the source of an image, lazy loaded images keep it in data-src
+func imageURL(img *html.Node, base *url.URL) string {
+	src := attr(img, "data-src")
+	if src == "" {
+		src = attr(img, "src")
+	}
+	if src == "" || strings.HasPrefix(src, "data:") {
+		return ""
+	}
+	u, err := base.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
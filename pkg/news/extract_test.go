@@ -0,0 +1,65 @@
+package news
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head><title>Trains</title><script>var tracking = "a, b, c, d, e, f, g";</script></head>
+<body>
+<header><nav><a href="/">Home</a> <a href="/news">News</a> <a href="/sport">Sport</a></nav></header>
+<div class="sidebar"><p>Subscribe to our newsletter, it is free, weekly, and full of offers.</p></div>
+<main>
+<article class="story">
+<h1>New trains for the coast</h1>
+<p><img src="/img/train.jpg" alt="">The first of the new trains, ordered in 2019, entered service on Monday.</p>
+<p>Passengers on the line to the coast get more seats, air conditioning, and sockets at every seat.</p>
+<img data-src="https://cdn.example.com/lazy.jpg" src="data:image/gif;base64,R0lGOD">
+<h2>Timetable</h2>
+<p>The timetable does not change, the trains run every half hour, also on weekends and holidays.</p>
+<ul class="share"><li><a href="https://facebook.com">Share on Facebook</a></li><li><a href="https://x.com">Share on X</a></li></ul>
+<div class="comments"><p>First! This comment is long enough to count as a paragraph, but it is not the article.</p></div>
+</article>
+</main>
+<footer><p>Copyright 2024 Example News, all rights reserved, no reproduction without permission.</p></footer>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	blocks, err := Extract([]byte(testPage), "https://example.com/news/trains.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Block{
+		{Text: "New trains for the coast", Heading: true},
+		{Image: "https://example.com/img/train.jpg"},
+		{Text: "The first of the new trains, ordered in 2019, entered service on Monday."},
+		{Text: "Passengers on the line to the coast get more seats, air conditioning, and sockets at every seat."},
+		{Image: "https://cdn.example.com/lazy.jpg"},
+		{Text: "Timetable", Heading: true},
+		{Text: "The timetable does not change, the trains run every half hour, also on weekends and holidays."},
+	}
+	if !reflect.DeepEqual(blocks, want) {
+		t.Errorf("Extract =\n%+v\nwant\n%+v", blocks, want)
+	}
+}
+
+func TestExtractNoContent(t *testing.T) {
+	pages := map[string]string{
+		"empty":      ``,
+		"short":      `<html><body><p>Too short.</p></body></html>`,
+		"navigation": `<html><body><nav><p>Home, News, Sport, Weather, Traffic, Contact, About us, Jobs</p></nav></body></html>`,
+		"links":      `<div><p><a href="/a">A link list that is long enough, with commas, to score</a></p></div>`,
+	}
+	for name, page := range pages {
+		if blocks, err := Extract([]byte(page), "https://example.com/"); !errors.Is(err, ErrNoContent) {
+			t.Errorf("%s: Extract = %+v, %v, want ErrNoContent", name, blocks, err)
+		}
+	}
+
+	if _, err := Extract([]byte(testPage), "://invalid"); err == nil {
+		t.Error("Extract with an invalid page URL did not fail")
+	}
+}
@@ -0,0 +1,175 @@
+package news
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Category is a feed category and how many stored articles are in it
+type Category struct {
+	Name  string
+	Count int
+}
+
+// posting is an article a term was found in
+type posting struct {
+	article *Article
+	inTitle bool
+}
+
+// Index is an in-memory inverted index over the titles and descriptions of
+// the stored articles, rebuilt after every poll
+type Index struct {
+	lock       sync.RWMutex
+	terms      map[string][]posting
+	categories map[string][]Category
+}
+
+func newIndex() *Index {
+	return &Index{terms: map[string][]posting{}, categories: map[string][]Category{}}
+}
+
+// build replaces the index with one over articles, grouped by feed
+func (i *Index) build(articles map[string][]Article) {
+	terms := map[string][]posting{}
+	categories := map[string][]Category{}
+
+	for feed, list := range articles {
+		counts := map[string]int{}
+		for n := range list {
+			a := &list[n]
+			seen := map[string]bool{}
+			for _, t := range tokenize(a.Title) {
+				if !seen[t] {
+					seen[t] = true
+					terms[t] = append(terms[t], posting{article: a, inTitle: true})
+				}
+			}
+			for _, t := range tokenize(plainText(a.Description)) {
+				if !seen[t] {
+					seen[t] = true
+					terms[t] = append(terms[t], posting{article: a})
+				}
+			}
+			for _, c := range a.Categories {
+				counts[c]++
+			}
+		}
+
+		for name, count := range counts {
+			categories[feed] = append(categories[feed], Category{Name: name, Count: count})
+		}
+		slices.SortFunc(categories[feed], func(a, b Category) int {
+			return strings.Compare(fold(a.Name), fold(b.Name))
+		})
+	}
+
+	i.lock.Lock()
+	i.terms, i.categories = terms, categories
+	i.lock.Unlock()
+}
+
+// Search returns the articles that contain every word of query, words
+// match as prefixes so "verkiez" finds "verkiezingen". Articles that
+// match in their title come first, then the newest.
+func (i *Index) Search(query string) []Article {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	var found map[*Article]int
+	for _, w := range words {
+		matches := map[*Article]int{}
+		for term, postings := range i.terms {
+			if !strings.HasPrefix(term, w) {
+				continue
+			}
+			for _, p := range postings {
+				if p.inTitle {
+					matches[p.article]++
+				} else if _, ok := matches[p.article]; !ok {
+					matches[p.article] = 0
+				}
+			}
+		}
+
+		if found == nil {
+			found = matches
+			continue
+		}
+		for a, score := range found {
+			if extra, ok := matches[a]; ok {
+				found[a] = score + extra
+			} else {
+				delete(found, a)
+			}
+		}
+	}
+
+	matched := make([]*Article, 0, len(found))
+	for a := range found {
+		matched = append(matched, a)
+	}
+	slices.SortFunc(matched, func(a, b *Article) int {
+		if found[a] != found[b] {
+			return found[b] - found[a]
+		}
+		return b.date().Compare(a.date())
+	})
+
+	results := make([]Article, len(matched))
+	for n, a := range matched {
+		results[n] = *a
+	}
+	return results
+}
+
+// Categories returns the categories of a feed by name
+func (i *Index) Categories(feed string) []Category {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.categories[feed]
+}
+
+// tokenize splits text into lowercase words without accents, so a search
+// for "ete" finds "été"
+func tokenize(s string) []string {
+	return strings.FieldsFunc(fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fold lowercases s and strips its accents
+func fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// plainText drops the markup from the HTML in a feed description
+func plainText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			b.Write(z.Text())
+			b.WriteByte(' ')
+		}
+	}
+}
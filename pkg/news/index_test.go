@@ -0,0 +1,82 @@
+package news
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func testIndex() *Index {
+	now := time.Now()
+	i := newIndex()
+	i.build(map[string][]Article{
+		"nl": {
+			{GUID: "verkiezingen", Title: "Verkiezingen in België", Description: "De <b>stembusgang</b> van zondag", Published: now.Add(-time.Hour), Categories: []string{"Politiek"}},
+			{GUID: "weer", Title: "Een warme zomer", Description: "Het wordt een hete été in de Ardennen", Published: now.Add(-2 * time.Hour), Categories: []string{"Weer", "Binnenland"}},
+			{GUID: "trein", Title: "Treinen rijden weer", Description: "Na de verkiezingen rijden de treinen", Published: now, Categories: []string{"binnenland"}},
+		},
+		"fr": {
+			{GUID: "ete", Title: "Un été très chaud", Description: "Élections à Liège", Published: now.Add(-3 * time.Hour), Categories: []string{"Météo"}},
+		},
+	})
+	return i
+}
+
+func guids(articles []Article) []string {
+	var ids []string
+	for _, a := range articles {
+		ids = append(ids, a.GUID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	i := testIndex()
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// a title match ranks before a newer description match
+		{"verkiezingen", []string{"verkiezingen", "trein"}},
+		{"verkiez", []string{"verkiezingen", "trein"}},
+		{"VERKIEZ", []string{"verkiezingen", "trein"}},
+		// accents are folded on both sides
+		{"belgie", []string{"verkiezingen"}},
+		{"België", []string{"verkiezingen"}},
+		{"ete", []string{"ete", "weer"}},
+		{"été", []string{"ete", "weer"}},
+		{"elections liege", []string{"ete"}},
+		// every word has to match, markup in descriptions is not searched
+		{"treinen verkiez", []string{"trein"}},
+		{"treinen zomer", nil},
+		{"stembus", []string{"verkiezingen"}},
+		// a prefix matches the start of a word only
+		{"kiezingen", nil},
+		{"", nil},
+		{" ,.! ", nil},
+	}
+	for _, tt := range tests {
+		if got := guids(i.Search(tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCategories(t *testing.T) {
+	i := testIndex()
+	got := i.Categories("nl")
+	want := []Category{{"binnenland", 1}, {"Binnenland", 1}, {"Politiek", 1}, {"Weer", 1}}
+	if len(got) != len(want) {
+		t.Fatalf("Categories = %v, want %v", got, want)
+	}
+	// sorted without regard to case, equal names in any order
+	for n := range got {
+		if fold(got[n].Name) != fold(want[n].Name) || got[n].Count != want[n].Count {
+			t.Errorf("Categories = %v, want %v", got, want)
+			break
+		}
+	}
+	if got := i.Categories("unknown"); got != nil {
+		t.Errorf("Categories of an unknown feed = %v", got)
+	}
+}
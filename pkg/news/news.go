@@ -0,0 +1,358 @@
+// Package news polls the RSS, Atom and JSON feeds shown in the news section
+package news
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/safefetch"
+	"github.com/mmcdole/gofeed"
+)
+
+// ErrUnknownFeed is returned for feed IDs that are not configured
+var ErrUnknownFeed = errors.New("unknown feed")
+
+// Feed is a news source
+type Feed struct {
+	ID       string
+	Title    string
+	URL      string
+	Language string
+
+	skip []*regexp.Regexp
+}
+
+// NewFeed creates a feed, items with a title matching one of the
+// skipTitles regular expressions are left out
+func NewFeed(id, title, url, language string, skipTitles []string) (*Feed, error) {
+	f := &Feed{ID: id, Title: title, URL: url, Language: language}
+	for _, expr := range skipTitles {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %w", id, err)
+		}
+		f.skip = append(f.skip, re)
+	}
+	return f, nil
+}
+
+// Skip reports whether an item with this title should be left out
+func (f *Feed) Skip(title string) bool {
+	for _, re := range f.skip {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+	return false
+}
+
+// Article is a feed item reduced to what a phone can show
+type Article struct {
+	GUID        string
+	Feed        string
+	Title       string
+	Description string
+	Link        string
+	ImageURL    string
+	Published   time.Time
+	Categories  []string `json:",omitempty"`
+
+	// FirstSeen and LastSeen are when the poller first and last found the article in its feed
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// date orders articles, feeds without publication dates fall back to when we first saw them
+func (a Article) date() time.Time {
+	if a.Published.IsZero() {
+		return a.FirstSeen
+	}
+	return a.Published
+}
+
+// fromItem converts a parsed item, gofeed maps RSS, Atom and JSON Feed onto the same fields
+func fromItem(feed string, item *gofeed.Item) Article {
+	a := Article{
+		GUID:        item.GUID,
+		Feed:        feed,
+		Title:       strings.TrimSpace(item.Title),
+		Description: strings.TrimSpace(item.Description),
+		Link:        item.Link,
+	}
+	for _, c := range item.Categories {
+		if c = strings.TrimSpace(c); c != "" && !slices.Contains(a.Categories, c) {
+			a.Categories = append(a.Categories, c)
+		}
+	}
+	if a.GUID == "" {
+		// Atom and JSON Feed entries without an id are identified by their link
+		a.GUID = item.Link
+	}
+	if item.PublishedParsed != nil {
+		a.Published = *item.PublishedParsed
+	} else if item.UpdatedParsed != nil {
+		a.Published = *item.UpdatedParsed
+	}
+
+	if item.Image != nil {
+		a.ImageURL = item.Image.URL
+	} else {
+		for _, enclosure := range item.Enclosures {
+			if strings.HasPrefix(enclosure.Type, "image") {
+				a.ImageURL = enclosure.URL
+				break
+			}
+		}
+	}
+	return a
+}
+
+// Aggregator polls the configured feeds in the background and serves
+// their articles from a Store, so page views never wait on a feed
+type Aggregator struct {
+	feeds  []*Feed
+	store  *Store
+	client *http.Client
+	// pages downloads the articles for extraction, feeds link to any site
+	pages *safefetch.Fetcher
+	index *Index
+
+	lock   sync.Mutex
+	errors map[string]error
+}
+
+func NewAggregator(feeds []*Feed, store *Store, pages *safefetch.Fetcher) *Aggregator {
+	return &Aggregator{
+		feeds:  feeds,
+		store:  store,
+		client: &http.Client{Timeout: 30 * time.Second},
+		pages:  pages,
+		index:  newIndex(),
+		errors: map[string]error{},
+	}
+}
+
+// Feeds returns the feeds in configured order
+func (a *Aggregator) Feeds() []*Feed {
+	return a.feeds
+}
+
+// Feed finds a feed by ID, an empty ID is the first feed
+func (a *Aggregator) Feed(id string) (*Feed, error) {
+	for _, f := range a.feeds {
+		if id == "" || f.ID == id {
+			return f, nil
+		}
+	}
+	return nil, ErrUnknownFeed
+}
+
+// Articles returns the stored articles of a feed, newest first
+func (a *Aggregator) Articles(feed *Feed) ([]Article, error) {
+	return a.store.Articles(feed.ID)
+}
+
+// Article returns one stored article, also after it left the feed
+func (a *Aggregator) Article(feed *Feed, guid string) (Article, error) {
+	return a.store.Article(feed.ID, guid)
+}
+
+// Search finds articles in all feeds, see Index.Search
+func (a *Aggregator) Search(query string) []Article {
+	return a.index.Search(query)
+}
+
+// Categories returns the categories used by the stored articles of a feed
+func (a *Aggregator) Categories(feed *Feed) []Category {
+	return a.index.Categories(feed.ID)
+}
+
+// CategoryArticles returns the stored articles of a feed in a category, newest first
+func (a *Aggregator) CategoryArticles(feed *Feed, category string) ([]Article, error) {
+	articles, err := a.store.Articles(feed.ID)
+	return slices.DeleteFunc(articles, func(article Article) bool {
+		return !slices.Contains(article.Categories, category)
+	}), err
+}
+
+// reindex rebuilds the search index from the store
+func (a *Aggregator) reindex() {
+	articles := map[string][]Article{}
+	for _, feed := range a.feeds {
+		list, err := a.store.Articles(feed.ID)
+		if err != nil {
+			log.Println("news: indexing", feed.ID, "failed:", err)
+			continue
+		}
+		articles[feed.ID] = list
+	}
+	a.index.build(articles)
+}
+
+// Body returns the full text of an article as the poller extracted it from
+// the linked page. A nil body means it was not extracted (yet) or the page
+// had no article we could find.
+func (a *Aggregator) Body(feed *Feed, article Article) ([]Block, error) {
+	blocks, _, err := a.store.Body(feed.ID, article.GUID)
+	return blocks, err
+}
+
+const (
+	// extractTimeout bounds downloading one article page
+	extractTimeout = 30 * time.Second
+	// extractRetry is how long a page that could not be downloaded is left alone
+	extractRetry = time.Hour
+)
+
+// extractAll extracts the stored articles of every feed that have no body yet
+func (a *Aggregator) extractAll(ctx context.Context) {
+	now := time.Now()
+	for _, feed := range a.feeds {
+		articles, err := a.store.Articles(feed.ID)
+		if err != nil {
+			log.Println("news: reading", feed.ID, "failed:", err)
+			continue
+		}
+		for _, article := range articles {
+			if ctx.Err() != nil {
+				return
+			}
+			if article.Link == "" || !a.store.needsBody(feed.ID, article.GUID, now) {
+				continue
+			}
+			if err := a.extract(ctx, feed, article); err != nil {
+				log.Println("news: extracting", article.Link, "failed:", err)
+			}
+		}
+	}
+}
+
+// extract downloads the page an article links to and stores its body,
+// a page we cannot download is retried after extractRetry
+func (a *Aggregator) extract(ctx context.Context, feed *Feed, article Article) error {
+	pageCtx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+
+	resp, err := a.pages.Get(pageCtx, article.Link)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutting down, the next run tries again
+			return nil
+		}
+		return errors.Join(err, a.store.saveFailure(feed.ID, article.GUID, time.Now().Add(extractRetry)))
+	}
+
+	var blocks []Block
+	if strings.Contains(resp.ContentType, "html") {
+		blocks, err = Extract(resp.Body, resp.URL)
+	} else {
+		err = ErrNoContent
+	}
+	if err != nil {
+		// downloading the page again gives the same result, remember it has no article
+		log.Println("news: no article found on", article.Link, err)
+		blocks = nil
+	}
+
+	return a.store.saveBody(feed.ID, article.GUID, blocks)
+}
+
+// Err returns the error of the last poll of a feed
+func (a *Aggregator) Err(feed *Feed) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.errors[feed.ID]
+}
+
+// Run polls every feed now and then every interval until ctx is done
+func (a *Aggregator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// articles from earlier runs are searchable before the first poll is done
+	a.reindex()
+
+	for {
+		a.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll refreshes all feeds once, prunes articles past retention, rebuilds
+// the search index and then extracts the new articles, so page views never
+// wait on an article page either
+func (a *Aggregator) Poll(ctx context.Context) {
+	for _, feed := range a.feeds {
+		err := a.poll(ctx, feed)
+		if err != nil {
+			log.Println("news: polling", feed.ID, "failed:", err)
+		}
+		a.lock.Lock()
+		a.errors[feed.ID] = err
+		a.lock.Unlock()
+	}
+
+	if removed, err := a.store.Prune(time.Now()); err != nil {
+		log.Println("news: pruning failed:", err)
+	} else if removed > 0 {
+		log.Println("news: pruned", removed, "articles")
+	}
+	a.reindex()
+	a.extractAll(ctx)
+}
+
+// poll downloads a feed with a conditional GET, an unchanged feed only
+// marks its articles as still seen
+func (a *Aggregator) poll(ctx context.Context, feed *Feed) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return err
+	}
+	etag, lastModified := a.store.validators(feed.ID)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return a.store.touch(feed.ID, now)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s answered %s", feed.URL, resp.Status)
+	}
+
+	parsed, err := gofeed.NewParser().Parse(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	articles := []Article{}
+	for _, item := range parsed.Items {
+		if feed.Skip(item.Title) {
+			continue
+		}
+		articles = append(articles, fromItem(feed.ID, item))
+	}
+	return a.store.update(feed.ID, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), articles, now)
+}
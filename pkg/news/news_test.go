@@ -0,0 +1,95 @@
+package news
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/safefetch"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "news.db"), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestExtractAll(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(testPage))
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("not a page"))
+		default:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	opts := safefetch.DefaultOptions
+	opts.AllowPrivate = true
+	store := openTestStore(t)
+	feed := &Feed{ID: "test"}
+	a := NewAggregator([]*Feed{feed}, store, safefetch.New(opts))
+
+	now := time.Now()
+	articles := []Article{
+		{GUID: "article", Link: srv.URL + "/article"},
+		{GUID: "text", Link: srv.URL + "/text"},
+		{GUID: "down", Link: srv.URL + "/down"},
+	}
+	if err := store.update(feed.ID, "", "", articles, now); err != nil {
+		t.Fatal(err)
+	}
+
+	a.extractAll(context.Background())
+	if hits.Load() != 3 {
+		t.Errorf("extractAll fetched %d pages, want 3", hits.Load())
+	}
+
+	blocks, ok, err := store.Body(feed.ID, "article")
+	if err != nil || !ok || len(blocks) == 0 || blocks[0].Text != "New trains for the coast" {
+		t.Errorf("Body(article) = %+v, %v, %v", blocks, ok, err)
+	}
+	// a page without an article is not fetched again
+	if blocks, ok, err := store.Body(feed.ID, "text"); err != nil || !ok || blocks != nil {
+		t.Errorf("Body(text) = %+v, %v, %v, want an empty extracted body", blocks, ok, err)
+	}
+	// a page that is down is tried again later
+	if _, ok, err := store.Body(feed.ID, "down"); err != nil || ok {
+		t.Errorf("Body(down) = %v, %v, want not extracted", ok, err)
+	}
+	if store.needsBody(feed.ID, "down", now) {
+		t.Error("failed page is fetched again right away")
+	}
+	if !store.needsBody(feed.ID, "down", now.Add(extractRetry+time.Minute)) {
+		t.Error("failed page is not fetched again after extractRetry")
+	}
+
+	a.extractAll(context.Background())
+	if hits.Load() != 3 {
+		t.Errorf("second extractAll fetched %d pages, want none", hits.Load()-3)
+	}
+
+	// the request path only reads the store
+	body, err := a.Body(feed, Article{GUID: "article"})
+	if err != nil || len(body) != len(blocks) {
+		t.Errorf("Aggregator.Body = %d blocks, %v, want %d", len(body), err, len(blocks))
+	}
+	if body, err := a.Body(feed, Article{GUID: "unknown"}); err != nil || body != nil {
+		t.Errorf("Aggregator.Body of an unextracted article = %+v, %v", body, err)
+	}
+}
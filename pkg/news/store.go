@@ -0,0 +1,271 @@
+package news
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	articlesBucket = []byte("articles")
+	feedsBucket    = []byte("feeds")
+	bodiesBucket   = []byte("bodies")
+)
+
+// ErrNotFound is returned for articles that are not in the store
+var ErrNotFound = errors.New("article not found")
+
+// feedState is what we remember about a feed between polls
+type feedState struct {
+	// ETag and LastModified are sent back for a conditional GET
+	ETag         string
+	LastModified string
+	// Current are the GUIDs in the last version of the feed we downloaded
+	Current []string
+}
+
+// Store keeps articles in a bbolt database so they can still be read after
+// they drop out of the feed, until retention has passed since they were
+// last seen in it
+type Store struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+func OpenStore(path string, retention time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{articlesBucket, feedsBucket, bodiesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db, retention: retention}, nil
+}
+
+// articles are keyed by feed ID and GUID so a feed is a prefix scan
+func articleKey(feed, guid string) []byte {
+	return []byte(feed + "\x00" + guid)
+}
+
+func (s *Store) state(tx *bolt.Tx, feed string) feedState {
+	var st feedState
+	if b := tx.Bucket(feedsBucket).Get([]byte(feed)); b != nil {
+		json.Unmarshal(b, &st)
+	}
+	return st
+}
+
+func (s *Store) validators(feed string) (etag, lastModified string) {
+	var st feedState
+	s.db.View(func(tx *bolt.Tx) error {
+		st = s.state(tx, feed)
+		return nil
+	})
+	return st.ETag, st.LastModified
+}
+
+// update stores a fresh download of feed, articles we already have keep
+// when we first saw them
+func (s *Store) update(feed, etag, lastModified string, articles []Article, now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(articlesBucket)
+		st := feedState{ETag: etag, LastModified: lastModified}
+
+		for _, a := range articles {
+			key := articleKey(feed, a.GUID)
+			if old := bucket.Get(key); old != nil {
+				var existing Article
+				if json.Unmarshal(old, &existing) == nil {
+					a.FirstSeen = existing.FirstSeen
+				}
+			}
+			if a.FirstSeen.IsZero() {
+				a.FirstSeen = now
+			}
+			a.LastSeen = now
+
+			b, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key, b); err != nil {
+				return err
+			}
+			st.Current = append(st.Current, a.GUID)
+		}
+
+		b, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(feedsBucket).Put([]byte(feed), b)
+	})
+}
+
+// touch marks the articles of the last download as seen, for a feed that did not change
+func (s *Store) touch(feed string, now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(articlesBucket)
+		for _, guid := range s.state(tx, feed).Current {
+			key := articleKey(feed, guid)
+			old := bucket.Get(key)
+			if old == nil {
+				continue
+			}
+			var a Article
+			if err := json.Unmarshal(old, &a); err != nil {
+				continue
+			}
+			a.LastSeen = now
+			b, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Articles returns the stored articles of a feed, newest first
+func (s *Store) Articles(feed string) ([]Article, error) {
+	articles := []Article{}
+	prefix := articleKey(feed, "")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(articlesBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var a Article
+			if err := json.Unmarshal(v, &a); err != nil {
+				continue
+			}
+			articles = append(articles, a)
+		}
+		return nil
+	})
+
+	slices.SortStableFunc(articles, func(a, b Article) int {
+		return b.date().Compare(a.date())
+	})
+	return articles, err
+}
+
+// Article looks up one article of a feed
+func (s *Store) Article(feed, guid string) (Article, error) {
+	var a Article
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(articlesBucket).Get(articleKey(feed, guid))
+		if b == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(b, &a)
+	})
+	return a, err
+}
+
+// body is the stored extraction of an article, Retry is set when the page
+// could not be downloaded and is tried again after it
+type body struct {
+	Blocks []Block   `json:",omitempty"`
+	Retry  time.Time `json:",omitzero"`
+}
+
+func (s *Store) body(feed, guid string) (body, bool, error) {
+	var b body
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bodiesBucket).Get(articleKey(feed, guid))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &b)
+	})
+	return b, found, err
+}
+
+// Body returns the extracted text of an article, ok is false when it was not extracted (yet)
+func (s *Store) Body(feed, guid string) (blocks []Block, ok bool, err error) {
+	b, found, err := s.body(feed, guid)
+	if err != nil || !found || !b.Retry.IsZero() {
+		return nil, false, err
+	}
+	return b.Blocks, true, nil
+}
+
+// needsBody reports whether an article still has to be extracted at now
+func (s *Store) needsBody(feed, guid string, now time.Time) bool {
+	b, found, err := s.body(feed, guid)
+	return err != nil || !found || !b.Retry.IsZero() && !now.Before(b.Retry)
+}
+
+// saveBody stores the extracted text of an article, an empty body
+// remembers that the page had nothing we could extract
+func (s *Store) saveBody(feed, guid string, blocks []Block) error {
+	return s.putBody(feed, guid, body{Blocks: blocks})
+}
+
+// saveFailure remembers that an article could not be downloaded until retry
+func (s *Store) saveFailure(feed, guid string, retry time.Time) error {
+	return s.putBody(feed, guid, body{Retry: retry})
+}
+
+func (s *Store) putBody(feed, guid string, b body) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bodiesBucket).Put(articleKey(feed, guid), v)
+	})
+}
+
+// Prune deletes articles that were last seen in their feed longer than retention ago
+func (s *Store) Prune(now time.Time) (int, error) {
+	var expired [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(articlesBucket)
+		err := bucket.ForEach(func(k, v []byte) error {
+			var a Article
+			if json.Unmarshal(v, &a) != nil || now.Sub(a.LastSeen) > s.retention {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// deleting while iterating skips keys in bbolt
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bodiesBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(expired), err
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
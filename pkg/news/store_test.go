@@ -0,0 +1,134 @@
+package news
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRSS(items ...string) string {
+	rss := `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title>`
+	for n := 0; n+1 < len(items); n += 2 {
+		rss += fmt.Sprintf(`<item><guid>%s</guid><title>%s</title><link>http://example.com/%s</link></item>`, items[n], items[n+1], items[n])
+	}
+	return rss + `</channel></rss>`
+}
+
+func TestRepoll(t *testing.T) {
+	var feedXML atomic.Value
+	feedXML.Store(testRSS("a", "First", "b", "Second"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := feedXML.Load().(string)
+		etag := fmt.Sprintf(`"%d"`, len(body))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	store := openTestStore(t)
+	feed := &Feed{ID: "test", URL: srv.URL}
+	a := NewAggregator([]*Feed{feed}, store, nil)
+	ctx := context.Background()
+
+	if err := a.poll(ctx, feed); err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Article(feed.ID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same feed again is answered with 304, only LastSeen moves
+	time.Sleep(10 * time.Millisecond)
+	if err := a.poll(ctx, feed); err != nil {
+		t.Fatal(err)
+	}
+	touched, err := store.Article(feed.ID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !touched.LastSeen.After(first.LastSeen) || !touched.FirstSeen.Equal(first.FirstSeen) {
+		t.Errorf("after a 304 first/last seen = %v/%v, was %v/%v", touched.FirstSeen, touched.LastSeen, first.FirstSeen, first.LastSeen)
+	}
+
+	// a changed title updates the article in place, b left the feed and is kept
+	feedXML.Store(testRSS("a", "First, updated", "c", "Third"))
+	if err := a.poll(ctx, feed); err != nil {
+		t.Fatal(err)
+	}
+	articles, err := store.Articles(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[string]string{}
+	for _, article := range articles {
+		if _, ok := titles[article.GUID]; ok {
+			t.Errorf("article %s is stored twice", article.GUID)
+		}
+		titles[article.GUID] = article.Title
+	}
+	want := map[string]string{"a": "First, updated", "b": "Second", "c": "Third"}
+	if fmt.Sprint(titles) != fmt.Sprint(want) {
+		t.Errorf("stored titles = %v, want %v", titles, want)
+	}
+	updated, _ := store.Article(feed.ID, "a")
+	if !updated.FirstSeen.Equal(first.FirstSeen) {
+		t.Errorf("updating the article moved FirstSeen from %v to %v", first.FirstSeen, updated.FirstSeen)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store := openTestStore(t)
+	now := time.Now()
+	retention := store.retention
+
+	// a was last seen past retention, b just within it, c in another feed is also old
+	if err := store.update("one", "", "", []Article{{GUID: "a"}}, now.Add(-retention-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.update("one", "", "", []Article{{GUID: "b"}}, now.Add(-retention+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.update("two", "", "", []Article{{GUID: "c"}}, now.Add(-2*retention)); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"one", "a"}, {"one", "b"}, {"two", "c"}} {
+		if err := store.saveBody(key[0], key[1], []Block{{Text: "body"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := store.Prune(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("Prune removed %d articles, want 2", removed)
+	}
+
+	for _, tt := range []struct {
+		feed, guid string
+		kept       bool
+	}{{"one", "a", false}, {"one", "b", true}, {"two", "c", false}} {
+		_, err := store.Article(tt.feed, tt.guid)
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("article %s/%s kept = %v, want %v", tt.feed, tt.guid, kept, tt.kept)
+		}
+		// the body goes with its article
+		if _, ok, _ := store.Body(tt.feed, tt.guid); ok != tt.kept {
+			t.Errorf("body of %s/%s kept = %v, want %v", tt.feed, tt.guid, ok, tt.kept)
+		}
+	}
+
+	if removed, err := store.Prune(now); err != nil || removed != 0 {
+		t.Errorf("second Prune = %d, %v, want nothing removed", removed, err)
+	}
+}
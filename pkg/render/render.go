@@ -0,0 +1,236 @@
+// Package render executes the WML templates under static/, parsed once at startup
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ContentType is the media type of rendered decks
+const ContentType = "text/vnd.wap.wml"
+
+// partialsDir holds templates that are shared by every deck instead of served on their own
+const partialsDir = "partials"
+
+var escaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+	"'", "&apos;",
+	"$", "$$",
+)
+
+// Escape makes s safe as WML text or attribute value, a lone $ would otherwise
+// start a WML variable reference
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Funcs are the helpers available in every template
+var Funcs = template.FuncMap{
+	// wml escapes text and attribute values
+	"wml": func(v any) string {
+		return Escape(fmt.Sprint(v))
+	},
+	// attr escapes and quotes an attribute value: title={{ attr .Title }}
+	"attr": func(v any) string {
+		return `"` + Escape(fmt.Sprint(v)) + `"`
+	},
+	// query escapes a value for use in the query string of an href
+	"query": func(v any) string {
+		return Escape(url.QueryEscape(fmt.Sprint(v)))
+	},
+}
+
+// Renderer holds the parsed templates, keyed by their path below dir like "nws/list.wml"
+type Renderer struct {
+	dir    string
+	reload bool
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	loaded    time.Time
+}
+
+// New parses every .wml file below dir, with reload set templates are
+// parsed again when a file changed on disk, handy while editing them
+func New(dir string, reload bool) (*Renderer, error) {
+	// WalkDir does not follow a symlinked root
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	r := &Renderer{dir: dir, reload: reload}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Renderer) load() error {
+	started := time.Now()
+
+	var partials, decks []string
+	err := filepath.WalkDir(r.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".wml" {
+			return err
+		}
+		rel, err := filepath.Rel(r.dir, path)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(rel, partialsDir+string(filepath.Separator)) {
+			partials = append(partials, path)
+		} else {
+			decks = append(decks, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	base := template.New("").Funcs(Funcs)
+	if len(partials) > 0 {
+		if base, err = base.ParseFiles(partials...); err != nil {
+			return err
+		}
+	}
+
+	templates := make(map[string]*template.Template, len(decks))
+	for _, path := range decks {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(r.dir, path)
+		name := filepath.ToSlash(rel)
+
+		tmpl, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if tmpl, err = tmpl.New(name).Parse(string(content)); err != nil {
+			return err
+		}
+		templates[name] = tmpl
+	}
+
+	r.mu.Lock()
+	r.templates = templates
+	r.loaded = started
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether a template was modified after the last load
+func (r *Renderer) changed() bool {
+	r.mu.RLock()
+	loaded := r.loaded
+	r.mu.RUnlock()
+
+	errChanged := errors.New("changed")
+	err := filepath.WalkDir(r.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".wml" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(loaded) {
+			return errChanged
+		}
+		return nil
+	})
+	return err != nil
+}
+
+// lookup returns the named template, parsing the templates again first when reloading
+func (r *Renderer) lookup(name string) (*template.Template, error) {
+	if r.reload && r.changed() {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.RLock()
+	tmpl, ok := r.templates[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("render: no template %q", name)
+	}
+	return tmpl, nil
+}
+
+// Static reports whether the named deck has no template actions, so its
+// source can be served as it is. Templated decks are only served through
+// their handlers.
+func (r *Renderer) Static(name string) bool {
+	tmpl, err := r.lookup(name)
+	if err != nil || tmpl.Tree == nil {
+		return false
+	}
+	for _, n := range tmpl.Tree.Root.Nodes {
+		if n.Type() != parse.NodeText {
+			return false
+		}
+	}
+	return true
+}
+
+// Execute renders the named template into a buffer, so a failing
+// template never sends half a deck
+func (r *Renderer) Execute(name string, data any) ([]byte, error) {
+	tmpl, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrorTemplate is the deck shown when something went wrong, it gets the text in .Message
+const ErrorTemplate = "error.wml"
+
+// Render answers the request with the named template, the name is kept
+// in the context as "template" for logging further down the chain. A
+// template that fails is answered with the error deck.
+func (r *Renderer) Render(c echo.Context, name string, data any) error {
+	c.Set("template", name)
+
+	deck, err := r.Execute(name, data)
+	if err != nil {
+		log.Println("Error rendering", name, err)
+		return r.renderError(c, http.StatusInternalServerError, "Sorry, this page could not be shown.")
+	}
+	return c.Blob(http.StatusOK, ContentType, deck)
+}
+
+func (r *Renderer) renderError(c echo.Context, status int, message string) error {
+	c.Set("template", ErrorTemplate)
+	deck, err := r.Execute(ErrorTemplate, struct{ Message string }{Message: message})
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+	return c.Blob(status, ContentType, deck)
+}
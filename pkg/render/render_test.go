@@ -0,0 +1,128 @@
+package render
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestFuncs(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		data any
+		want string
+	}{
+		{`{{ wml . }}`, `Tom & Jerry <3 "quotes" 'apos'`, `Tom &amp; Jerry &lt;3 &quot;quotes&quot; &apos;apos&apos;`},
+		{`{{ wml . }}`, `costs $5 or $(price)`, `costs $$5 or $$(price)`},
+		{`{{ wml . }}`, 42, `42`},
+		{`<card title={{ attr . }}>`, `"Hi" & $name`, `<card title="&quot;Hi&quot; &amp; $$name">`},
+		{`<go href="/s?q={{ query . }}"/>`, `a b&c=$d/é`, `<go href="/s?q=a+b%26c%3D%24d%2F%C3%A9"/>`},
+		{`{{ query . }}`, `$`, `%24`},
+	}
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("").Funcs(Funcs).Parse(tt.tmpl))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, tt.data); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s with %q = %q, want %q", tt.tmpl, tt.data, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	// every special character once, and nothing is escaped twice
+	if got := Escape(`&<>"'$`); got != `&amp;&lt;&gt;&quot;&apos;$$` {
+		t.Errorf("Escape = %q", got)
+	}
+	if got := Escape(`&amp; $$`); got != `&amp;amp; $$$$` {
+		t.Errorf("Escape of escaped text = %q", got)
+	}
+}
+
+func newTestRenderer(t *testing.T) *Renderer {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"partials/back.wml": `{{ define "back" }}<do type="prev"><prev/></do>{{ end }}`,
+		"error.wml":         `<wml><card id="e"><p>{{ wml .Message }}</p></card></wml>`,
+		"static.wml":        `<wml><card id="s"><p>Hello</p></card></wml>`,
+		"section/page.wml":  `<wml><card id="p"><p>{{ wml .Name }}</p>{{ template "back" }}</card></wml>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := New(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRender(t *testing.T) {
+	r := newTestRenderer(t)
+	e := echo.New()
+
+	render := func(name string, data any) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		if err := r.Render(c, name, data); err != nil {
+			t.Fatalf("Render(%s) = %v", name, err)
+		}
+		return rec
+	}
+
+	rec := render("section/page.wml", struct{ Name string }{"A & B"})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("Render = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if want := `<p>A &amp; B</p><do type="prev"><prev/></do>`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("Render = %q, want it to contain %q", rec.Body.String(), want)
+	}
+
+	// the data has no Name field, execution fails half way through the deck
+	rec = render("section/page.wml", struct{ Title string }{"x"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("failing template answered %d, want 500", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `<card id="e">`) || strings.Contains(body, `<card id="p">`) {
+		t.Errorf("failing template sent %q, want only the error deck", body)
+	}
+	if rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("failing template sent %s", rec.Header().Get("Content-Type"))
+	}
+
+	rec = render("missing.wml", nil)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `<card id="e">`) {
+		t.Errorf("unknown template = %d %q, want the error deck", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStatic(t *testing.T) {
+	r := newTestRenderer(t)
+	for name, want := range map[string]bool{
+		"static.wml":        true,
+		"error.wml":         false,
+		"section/page.wml":  false,
+		"partials/back.wml": false,
+		"missing.wml":       false,
+	} {
+		if got := r.Static(name); got != want {
+			t.Errorf("Static(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
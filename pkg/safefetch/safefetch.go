@@ -0,0 +1,206 @@
+// Package safefetch downloads user supplied URLs without letting them reach
+// our internal network or exhaust our memory
+package safefetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBlockedAddress = errors.New("safefetch: address is not publicly routable")
+	ErrBlockedHost    = errors.New("safefetch: host is not allowed")
+	ErrScheme         = errors.New("safefetch: only http and https are allowed")
+	ErrTooManyHops    = errors.New("safefetch: too many redirects")
+	ErrTooLarge       = errors.New("safefetch: response too large")
+)
+
+// StatusError is returned when the origin answers with a non 2xx status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("safefetch: origin returned status %d", e.StatusCode)
+}
+
+type Options struct {
+	Timeout      time.Duration
+	MaxBytes     int64
+	MaxRedirects int
+	// AllowHosts limits requests to these hosts and their subdomains, empty allows all
+	AllowHosts []string
+	// DenyHosts blocks these hosts and their subdomains
+	DenyHosts []string
+	// AllowPrivate disables the private address check, only meant for development
+	AllowPrivate bool
+}
+
+var DefaultOptions = Options{
+	Timeout:      10 * time.Second,
+	MaxBytes:     5 << 20,
+	MaxRedirects: 3,
+}
+
+type Response struct {
+	Body        []byte
+	ContentType string
+	URL         string
+}
+
+type Fetcher struct {
+	opts   Options
+	client *http.Client
+}
+
+// extra ranges the net/netip helpers do not consider special
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	// 6to4 and Teredo embed an IPv4 address, including private ones
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("2001::/32"),
+}
+
+// IsPublic reports whether ip is a publicly routable unicast address
+func IsPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func New(opts Options) *Fetcher {
+	f := &Fetcher{opts: opts}
+
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		// Control runs after DNS resolution for every address we connect to,
+		// so rebinding tricks and redirects cannot sneak past it
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if opts.AllowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublic(addrPort.Addr()) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	f.client = &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   opts.Timeout,
+			ResponseHeaderTimeout: opts.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyHops
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+
+	return f
+}
+
+func hostMatches(host string, list []string) bool {
+	for _, h := range list {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrScheme
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if hostMatches(host, f.opts.DenyHosts) {
+		return ErrBlockedHost
+	}
+	if len(f.opts.AllowHosts) > 0 && !hostMatches(host, f.opts.AllowHosts) {
+		return ErrBlockedHost
+	}
+	return nil
+}
+
+// Get downloads rawURL, failing if the body is larger than MaxBytes
+func (f *Fetcher) Get(ctx context.Context, rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	if f.opts.MaxBytes > 0 && resp.ContentLength > f.opts.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	var body []byte
+	if f.opts.MaxBytes > 0 {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, f.opts.MaxBytes+1))
+	} else {
+		body, err = io.ReadAll(resp.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.opts.MaxBytes > 0 && int64(len(body)) > f.opts.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	return &Response{
+		Body:        body,
+		ContentType: resp.Header.Get("Content-Type"),
+		URL:         resp.Request.URL.String(),
+	}, nil
+}
@@ -0,0 +1,135 @@
+package safefetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"193.190.198.27", true},
+		{"2001:4860:4860::8888", true},
+		{"::ffff:8.8.8.8", true},
+
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+
+		{"127.0.0.1", false},
+		{"127.255.255.254", false},
+		{"::1", false},
+
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"64:ff9b::a00:1", false},
+		{"2002:a00:1::1", false},
+		{"2002:808:808::1", false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", false},
+		{"2001:1::1", true},
+
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:192.168.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := IsPublic(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("IsPublic(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if IsPublic(netip.Addr{}) {
+		t.Error("IsPublic(zero address) = true")
+	}
+}
+
+func TestGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// the test server listens on loopback
+	if _, err := New(DefaultOptions).Get(ctx, srv.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Get on loopback: %v, want %v", err, ErrBlockedAddress)
+	}
+
+	opts := DefaultOptions
+	opts.AllowPrivate = true
+	opts.MaxBytes = 10
+	f := New(opts)
+
+	resp, err := f.Get(ctx, srv.URL+"/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body) != "hello" || resp.ContentType != "text/plain" {
+		t.Errorf("Get = %q, %q", resp.Body, resp.ContentType)
+	}
+
+	if _, err := f.Get(ctx, srv.URL+"/large"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Get of a large body: %v, want %v", err, ErrTooLarge)
+	}
+	var status *StatusError
+	if _, err := f.Get(ctx, srv.URL+"/missing"); !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Errorf("Get of a missing page: %v, want status 404", err)
+	}
+	if _, err := f.Get(ctx, "file:///etc/passwd"); !errors.Is(err, ErrScheme) {
+		t.Errorf("Get of a file URL: %v, want %v", err, ErrScheme)
+	}
+}
+
+func TestHostLists(t *testing.T) {
+	f := New(Options{AllowHosts: []string{"example.com"}, DenyHosts: []string{"bad.example.com"}})
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"http://example.com/", nil},
+		{"https://www.EXAMPLE.com/", nil},
+		{"http://bad.example.com/", ErrBlockedHost},
+		{"http://very.bad.example.com/", ErrBlockedHost},
+		{"http://notexample.com/", ErrBlockedHost},
+		{"http://example.com.evil.org/", ErrBlockedHost},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.checkURL(u); !errors.Is(err, tt.want) {
+			t.Errorf("checkURL(%s) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package text shortens and pages text for small screens without cutting
+// through characters, entities or, where possible, words
+package text
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ellipsis marks text that was cut short, plain dots as most phones are latin1
+const Ellipsis = "..."
+
+// zeroWidthJoiner glues emoji sequences together
+const zeroWidthJoiner = '‍'
+
+// next returns the length in bytes of the character at the start of s. An
+// entity like &amp; is one character, as is a letter with the combining
+// marks that follow it or an emoji joined with zero width joiners.
+func next(s string) int {
+	if s == "" {
+		return 0
+	}
+	if s[0] == '&' {
+		if n := entity(s); n > 0 {
+			return n
+		}
+	}
+
+	_, n := utf8.DecodeRuneInString(s)
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		switch {
+		case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r):
+			n += size
+		case r == zeroWidthJoiner:
+			n += size
+			if n < len(s) {
+				_, size = utf8.DecodeRuneInString(s[n:])
+				n += size
+			}
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// entity returns the length of the character reference at the start of s, or
+// 0 if s does not start with one
+func entity(s string) int {
+	for i := 1; i < len(s) && i <= 32; i++ {
+		c := s[i]
+		switch {
+		case c == ';':
+			if i == 1 {
+				return 0
+			}
+			return i + 1
+		case c == '#' && i == 1,
+			c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// Len counts the characters in s the way Truncate and Paginate do
+func Len(s string) int {
+	count := 0
+	for s != "" {
+		s = s[next(s):]
+		count++
+	}
+	return count
+}
+
+// Split cuts s after at most n characters, at the last space that keeps the
+// head at least half full, or mid word when there is none. The tail does not
+// start with a space.
+func Split(s string, n int) (head, tail string) {
+	if n <= 0 {
+		return "", strings.TrimLeftFunc(s, unicode.IsSpace)
+	}
+
+	end, space := 0, -1
+	for count := 0; end < len(s); count++ {
+		size := next(s[end:])
+		if count == n {
+			// a cut right before a space is a word boundary too
+			if r, _ := utf8.DecodeRuneInString(s[end:]); unicode.IsSpace(r) {
+				space = end
+			}
+			if space >= 0 && Len(s[:space]) >= n/2 {
+				end = space
+			}
+			return strings.TrimRightFunc(s[:end], unicode.IsSpace), strings.TrimLeftFunc(s[end:], unicode.IsSpace)
+		}
+		if r, _ := utf8.DecodeRuneInString(s[end:]); unicode.IsSpace(r) {
+			space = end
+		}
+		end += size
+	}
+	return s, ""
+}
+
+// Truncate shortens s to at most n characters, including the ellipsis it
+// adds when anything was cut. When n leaves no room for the ellipsis the
+// first n characters are returned as they are.
+func Truncate(s string, n int) string {
+	if Len(s) <= n {
+		return s
+	}
+	if n < len(Ellipsis) {
+		end := 0
+		for count := 0; count < n; count++ {
+			end += next(s[end:])
+		}
+		return s[:end]
+	}
+	head, _ := Split(s, n-len(Ellipsis))
+	return trimEnd(head) + Ellipsis
+}
+
+// trimEnd drops the spaces and punctuation an ellipsis would follow, the ;
+// closing an entity belongs to it and stays
+func trimEnd(s string) string {
+	ends := []int{}
+	for end := 0; end < len(s); end += next(s[end:]) {
+		ends = append(ends, end)
+	}
+	for i := len(ends) - 1; i >= 0; i-- {
+		c := s[ends[i]:]
+		r, size := utf8.DecodeRuneInString(c)
+		if size != len(c) || !unicode.IsSpace(r) && !(unicode.IsPunct(r) && r != ')' && r != '"') {
+			break
+		}
+		s = s[:ends[i]]
+	}
+	return s
+}
+
+// Paginate splits s into pages of at most size characters, breaking between words
+func Paginate(s string, size int) []string {
+	if size <= 0 {
+		return []string{s}
+	}
+	pages := []string{}
+	for {
+		head, tail := Split(s, size)
+		pages = append(pages, head)
+		if tail == "" {
+			return pages
+		}
+		s = tail
+	}
+}
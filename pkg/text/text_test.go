@@ -0,0 +1,110 @@
+package text
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLen(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"café", 4},
+		{"Noël à Liège", 12},
+		{"garçon", 6},
+		{"a &amp; b", 5},
+		{"&#233;t&#xe9;", 3},
+		{"&nbsp", 5},
+		{"& b", 3},
+		{"cafe\u0301", 4},
+		{"e\u0301\u0308", 1},
+		{"\U0001F468\u200d\U0001F469\u200d\U0001F467", 1},
+	}
+	for _, tt := range tests {
+		if got := Len(tt.in); got != tt.want {
+			t.Errorf("Len(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		in         string
+		n          int
+		head, tail string
+	}{
+		{"hello world", 20, "hello world", ""},
+		{"hello world", 8, "hello", "world"},
+		{"hello world", 5, "hello", "world"},
+		{"helloworld", 5, "hello", "world"},
+		{"a verylongword", 8, "a verylo", "ngword"},
+		{"hello world", 0, "", "hello world"},
+		{"  hello", 0, "", "hello"},
+		{"été déjà vu", 6, "été", "déjà vu"},
+		{"ça va très bien", 10, "ça va très", "bien"},
+		{"ça va très bien", 9, "ça va", "très bien"},
+		{"a&amp;b&amp;c", 3, "a&amp;b", "&amp;c"},
+		{"cafe\u0301s", 4, "cafe\u0301", "s"},
+	}
+	for _, tt := range tests {
+		head, tail := Split(tt.in, tt.n)
+		if head != tt.head || tail != tt.tail {
+			t.Errorf("Split(%q, %d) = %q, %q, want %q, %q", tt.in, tt.n, head, tail, tt.head, tt.tail)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"abc", 3, "abc"},
+		{"abc", 2, "ab"},
+		{"abc", 1, "a"},
+		{"abc", 0, ""},
+		{"éèê", 2, "éè"},
+		{"&amp;&amp;&amp;&amp;", 2, "&amp;&amp;"},
+		{"hello world", 10, "hello..."},
+		{"hello, world", 10, "hello..."},
+		{"Noël in Liège", 9, "Noël..."},
+		{"français", 7, "fran..."},
+		{"a &eacute; b c d", 6, "a &eacute;..."},
+		{"cafe\u0301 cre\u0300me", 8, "cafe\u0301..."},
+	}
+	for _, tt := range tests {
+		got := Truncate(tt.in, tt.n)
+		if got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+		if Len(got) > tt.n {
+			t.Errorf("Truncate(%q, %d) is %d characters long", tt.in, tt.n, Len(got))
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		in   string
+		size int
+		want []string
+	}{
+		{"", 5, []string{""}},
+		{"short", 0, []string{"short"}},
+		{"one two three", 20, []string{"one two three"}},
+		{"one two three", 7, []string{"one two", "three"}},
+		{"abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"déjà vu à Liège", 8, []string{"déjà vu", "à Liège"}},
+		{"&lt;&lt;&lt;&lt;", 2, []string{"&lt;&lt;", "&lt;&lt;"}},
+		{"e\u0301e\u0301e\u0301", 2, []string{"e\u0301e\u0301", "e\u0301"}},
+	}
+	for _, tt := range tests {
+		if got := Paginate(tt.in, tt.size); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Paginate(%q, %d) = %q, want %q", tt.in, tt.size, got, tt.want)
+		}
+	}
+}
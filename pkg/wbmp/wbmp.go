@@ -0,0 +1,164 @@
+// Package wbmp implements a decoder and encoder for Wireless Bitmap (WBMP) Type 0 images
+// as defined in the WAP Wireless Application Environment specification.
+//
+// A Type 0 WBMP is a header of multi-byte integers (type, fixed header, width, height)
+// followed by 1-bit rows, each padded to a whole byte, where a set bit is white.
+package wbmp
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Palette is the palette of decoded images, index 0 is black and index 1 is white
+var Palette = color.Palette{color.Black, color.White}
+
+var (
+	ErrUnsupportedType = errors.New("wbmp: unsupported type, only type 0 is supported")
+	ErrInvalidHeader   = errors.New("wbmp: invalid header")
+)
+
+// maxDimension guards against headers that would make us allocate absurd amounts of memory,
+// no phone ever rendered a WBMP anywhere near this size
+const maxDimension = 1 << 12
+
+func init() {
+	image.RegisterFormat("wbmp", "\x00\x00", Decode, DecodeConfig)
+}
+
+// readMultiByte reads a multi-byte integer, 7 bits per byte with the
+// high bit set on every byte except the last
+func readMultiByte(r io.ByteReader) (int, error) {
+	n := 0
+	for range 5 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, ErrInvalidHeader
+}
+
+func writeMultiByte(w io.ByteWriter, n int) error {
+	var buf [5]byte
+	i := len(buf) - 1
+	buf[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		buf[i] = byte(n&0x7f) | 0x80
+	}
+	for _, b := range buf[i:] {
+		if err := w.WriteByte(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readHeader(r io.ByteReader) (image.Config, error) {
+	typ, err := readMultiByte(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if typ != 0 {
+		return image.Config{}, ErrUnsupportedType
+	}
+
+	// fixed header field, extension headers are not used by type 0
+	fixed, err := r.ReadByte()
+	if err != nil {
+		return image.Config{}, err
+	}
+	if fixed&0x80 != 0 {
+		return image.Config{}, ErrInvalidHeader
+	}
+
+	width, err := readMultiByte(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	height, err := readMultiByte(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if width <= 0 || height <= 0 || width > maxDimension || height > maxDimension {
+		return image.Config{}, ErrInvalidHeader
+	}
+
+	return image.Config{ColorModel: Palette, Width: width, Height: height}, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a WBMP image without decoding the entire image
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	return readHeader(bufio.NewReader(r))
+}
+
+// Decode reads a WBMP image from r and returns it as an *image.Paletted using Palette
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	cfg, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the rows before allocating the image, so a header promising a
+	// large image costs nothing unless the data is really there
+	stride := (cfg.Width + 7) / 8
+	data, err := io.ReadAll(io.LimitReader(br, int64(stride*cfg.Height)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < stride*cfg.Height {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, cfg.Width, cfg.Height), Palette)
+	for y := range cfg.Height {
+		row := data[y*stride:]
+		pix := img.Pix[y*img.Stride:]
+		for x := range cfg.Width {
+			pix[x] = (row[x/8] >> (7 - uint(x%8))) & 1
+		}
+	}
+
+	return img, nil
+}
+
+// Encode writes m to w as a WBMP image, pixels with a luminance of
+// at least half are white, everything else is black.
+// Dither the image first if it is not black and white already.
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return errors.New("wbmp: empty image")
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteByte(0) // type 0
+	bw.WriteByte(0) // fixed header
+	writeMultiByte(bw, b.Dx())
+	writeMultiByte(bw, b.Dy())
+
+	row := make([]byte, (b.Dx()+7)/8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		clear(row)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(m.At(x, y)).(color.Gray).Y >= 0x80 {
+				i := x - b.Min.X
+				row[i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
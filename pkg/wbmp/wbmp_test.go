@@ -0,0 +1,113 @@
+package wbmp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// checkerboard returns a black and white image with a size that does not
+// fill whole bytes, the top left pixel is white
+func checkerboard(w, h int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, w, h), Palette)
+	for y := range h {
+		for x := range w {
+			if (x+y)%2 == 0 || x == w-1 {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {8, 2}, {13, 5}, {200, 3}} {
+		want := checkerboard(size.X, size.Y)
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, want); err != nil {
+			t.Fatal(err)
+		}
+		if rowBytes := (size.X + 7) / 8; buf.Len() < rowBytes*size.Y {
+			t.Errorf("%v: %d bytes is too short", size, buf.Len())
+		}
+
+		cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil || cfg.Width != size.X || cfg.Height != size.Y {
+			t.Errorf("%v: DecodeConfig = %+v, %v", size, cfg, err)
+		}
+
+		got, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if format != "wbmp" {
+			t.Errorf("%v: format %q", size, format)
+		}
+		if got.Bounds() != want.Bounds() {
+			t.Fatalf("%v: bounds %v", size, got.Bounds())
+		}
+		if !bytes.Equal(got.(*image.Paletted).Pix, want.Pix) {
+			t.Errorf("%v: pixels changed", size)
+		}
+	}
+}
+
+func TestEncodeThreshold(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 1))
+	img.SetGray(0, 0, color.Gray{Y: 0x7f})
+	img.SetGray(1, 0, color.Gray{Y: 0x80})
+	img.SetGray(2, 0, color.Gray{Y: 0xff})
+
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 3, 1, 0x60}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Encode = %x, want %x", buf.Bytes(), want)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"type 1", []byte{1, 0, 1, 1, 0}, ErrUnsupportedType},
+		{"extension header", []byte{0, 0x80, 1, 1, 0}, ErrInvalidHeader},
+		{"zero width", []byte{0, 0, 0, 1}, ErrInvalidHeader},
+		{"zero height", []byte{0, 0, 1, 0}, ErrInvalidHeader},
+		{"too wide", []byte{0, 0, 0xa0, 0x01, 1}, ErrInvalidHeader},
+		{"too high", []byte{0, 0, 1, 0x81, 0x80, 0x01}, ErrInvalidHeader},
+		{"16384 square", []byte{0, 0, 0x81, 0x80, 0x00, 0x81, 0x80, 0x00}, ErrInvalidHeader},
+		{"endless integer", []byte{0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, ErrInvalidHeader},
+		{"missing height", []byte{0, 0, 1}, io.EOF},
+		{"missing rows", []byte{0, 0, 9, 2, 0xff, 0x80}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(bytes.NewReader(tt.data)); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Decode error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDecodeAllocatesForData(t *testing.T) {
+	// a header for the largest image we accept but no rows behind it
+	header := []byte{0, 0, 0xa0, 0x00, 0xa0, 0x00}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Decode(bytes.NewReader(header))
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Decode error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("Decode allocated %d bytes for a header", n)
+	}
+}
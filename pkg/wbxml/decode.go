@@ -0,0 +1,283 @@
+package wbxml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+)
+
+var ErrTruncated = errors.New("wbxml: truncated document")
+
+type decoder struct {
+	data   []byte
+	pos    int
+	latin1 bool
+	strtbl []byte
+}
+
+var docTypes = map[uint32]struct{ version, docType string }{
+	publicIDWML11: {"1.1", `DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.1//EN" "http://www.wapforum.org/DTD/wml_1.1.xml"`},
+	publicIDWML12: {"1.2", `DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.2//EN" "http://www.wapforum.org/DTD/wml12.dtd"`},
+	publicIDWML13: {"1.3", `DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.3//EN" "http://www.wapforum.org/DTD/wml13.dtd"`},
+}
+
+// Decode turns a compiled WML deck back into a document, it is the inverse of
+// Encode and mostly useful to check what we are sending to phones
+func Decode(data []byte) (*wml.Document, error) {
+	d := &decoder{data: data}
+
+	if _, err := d.byte(); err != nil { // WBXML version
+		return nil, err
+	}
+	publicID, err := d.multiByte()
+	if err != nil {
+		return nil, err
+	}
+	dt, ok := docTypes[publicID]
+	if !ok {
+		return nil, fmt.Errorf("wbxml: unsupported public ID %#x", publicID)
+	}
+
+	charset, err := d.multiByte()
+	if err != nil {
+		return nil, err
+	}
+	doc := &wml.Document{DocType: dt.docType, Encoding: "utf-8"}
+	switch charset {
+	case MIBUTF8:
+	case MIBISO8859_1:
+		d.latin1 = true
+		doc.Encoding = "iso-8859-1"
+	case MIBUSASCII:
+		// a subset of ISO-8859-1, read the same way
+		d.latin1 = true
+		doc.Encoding = "us-ascii"
+	default:
+		return nil, fmt.Errorf("wbxml: unsupported charset %d", charset)
+	}
+
+	tblLen, err := d.multiByte()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos+int(tblLen) > len(d.data) {
+		return nil, ErrTruncated
+	}
+	d.strtbl = d.data[d.pos : d.pos+int(tblLen)]
+	d.pos += int(tblLen)
+
+	token, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	doc.Root, err = d.element(token)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) multiByte() (uint32, error) {
+	var n uint32
+	for range 5 {
+		b, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("wbxml: invalid multi-byte integer")
+}
+
+func (d *decoder) decodeString(b []byte) string {
+	if !d.latin1 {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func (d *decoder) termstr() (string, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 0)
+	if end < 0 {
+		return "", ErrTruncated
+	}
+	s := d.decodeString(d.data[d.pos : d.pos+end])
+	d.pos += end + 1
+	return s, nil
+}
+
+func (d *decoder) tableString(offset uint32) (string, error) {
+	if int(offset) >= len(d.strtbl) {
+		return "", fmt.Errorf("wbxml: string table offset %d out of range", offset)
+	}
+	end := bytes.IndexByte(d.strtbl[offset:], 0)
+	if end < 0 {
+		return "", ErrTruncated
+	}
+	return d.decodeString(d.strtbl[offset : int(offset)+end]), nil
+}
+
+func tagName(token byte) (string, bool) {
+	for name, t := range tags {
+		if t.token == token {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (d *decoder) element(token byte) (*wml.Node, error) {
+	name, ok := tagName(token &^ (tagHasAttributes | tagHasContent))
+	if !ok {
+		return nil, fmt.Errorf("wbxml: unknown tag token %#x", token)
+	}
+	n := wml.NewElement(name)
+
+	if token&tagHasAttributes != 0 {
+		if err := d.attributes(n); err != nil {
+			return nil, err
+		}
+	}
+
+	if token&tagHasContent == 0 {
+		return n, nil
+	}
+	for {
+		t, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if t == tokenEnd {
+			return n, nil
+		}
+
+		text, isText, err := d.text(t, false)
+		if err != nil {
+			return nil, err
+		}
+		if isText {
+			// glue consecutive strings and variables into one text node
+			if last := len(n.Children) - 1; last >= 0 && n.Children[last].Type == wml.TextNode {
+				n.Children[last].Text += text
+			} else {
+				n.Children = append(n.Children, wml.NewText(text))
+			}
+			continue
+		}
+
+		child, err := d.element(t)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = append(n.Children, child)
+	}
+}
+
+// text decodes a string, entity or variable token, it reports false for any other token
+func (d *decoder) text(t byte, attr bool) (string, bool, error) {
+	escapeDollar := func(s string) string {
+		return strings.ReplaceAll(s, "$", "$$")
+	}
+
+	switch t {
+	case tokenStrI:
+		s, err := d.termstr()
+		return escapeDollar(s), true, err
+	case tokenStrT:
+		offset, err := d.multiByte()
+		if err != nil {
+			return "", true, err
+		}
+		s, err := d.tableString(offset)
+		return escapeDollar(s), true, err
+	case tokenEntity:
+		r, err := d.multiByte()
+		return string(rune(r)), true, err
+	case tokenExtI0, tokenExtI1, tokenExtI2:
+		name, err := d.termstr()
+		switch t {
+		case tokenExtI0:
+			return "$(" + name + ":e)", true, err
+		case tokenExtI1:
+			return "$(" + name + ":u)", true, err
+		}
+		return "$(" + name + ")", true, err
+	case tokenOpaque:
+		return "", false, errors.New("wbxml: opaque data is not supported")
+	}
+
+	if attr {
+		for _, v := range attrValues {
+			if v.token == t {
+				return v.value, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+func (d *decoder) attributes(n *wml.Node) error {
+	var current *wml.Attr
+	for {
+		t, err := d.byte()
+		if err != nil {
+			return err
+		}
+		if t == tokenEnd {
+			if current != nil {
+				n.Attrs = append(n.Attrs, *current)
+			}
+			return nil
+		}
+
+		if t < 0x80 && t != tokenStrI && t != tokenEntity && t != tokenExtI0 && t != tokenExtI1 && t != tokenExtI2 {
+			// a new attribute start
+			if current != nil {
+				n.Attrs = append(n.Attrs, *current)
+			}
+			var start *attrStart
+			for i := range attrStarts {
+				if attrStarts[i].token == t {
+					start = &attrStarts[i]
+					break
+				}
+			}
+			if start == nil {
+				return fmt.Errorf("wbxml: unknown attribute token %#x", t)
+			}
+			current = &wml.Attr{Name: start.name, Value: start.prefix}
+			continue
+		}
+
+		if current == nil {
+			return fmt.Errorf("wbxml: attribute value token %#x without attribute", t)
+		}
+		text, ok, err := d.text(t, true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("wbxml: unexpected token %#x in attribute", t)
+		}
+		current.Value += text
+	}
+}
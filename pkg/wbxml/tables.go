@@ -0,0 +1,207 @@
+package wbxml
+
+// Global tokens, WBXML 1.3 section 7.1
+const (
+	tokenSwitchPage = 0x00
+	tokenEnd        = 0x01
+	tokenEntity     = 0x02
+	tokenStrI       = 0x03
+	tokenLiteral    = 0x04
+	tokenExtI0      = 0x40
+	tokenExtI1      = 0x41
+	tokenExtI2      = 0x42
+	tokenPI         = 0x43
+	tokenStrT       = 0x83
+	tokenOpaque     = 0xC3
+
+	tagHasAttributes = 0x80
+	tagHasContent    = 0x40
+)
+
+// Public identifiers of the WML versions, WAP-192 appendix and the WINA registry
+const (
+	publicIDWML11 = 0x04
+	publicIDWML12 = 0x09
+	publicIDWML13 = 0x0A
+)
+
+// Character sets by IANA MIBenum
+const (
+	MIBUTF8      = 106
+	MIBISO8859_1 = 4
+	MIBUSASCII   = 3
+)
+
+// tags is WML code page 0, the version is the WML version that introduced the tag
+var tags = map[string]struct {
+	token   byte
+	version string
+}{
+	"pre":       {0x1B, "1.3"},
+	"a":         {0x1C, "1.1"},
+	"td":        {0x1D, "1.1"},
+	"tr":        {0x1E, "1.1"},
+	"table":     {0x1F, "1.1"},
+	"p":         {0x20, "1.1"},
+	"postfield": {0x21, "1.1"},
+	"anchor":    {0x22, "1.1"},
+	"access":    {0x23, "1.1"},
+	"b":         {0x24, "1.1"},
+	"big":       {0x25, "1.1"},
+	"br":        {0x26, "1.1"},
+	"card":      {0x27, "1.1"},
+	"do":        {0x28, "1.1"},
+	"em":        {0x29, "1.1"},
+	"fieldset":  {0x2A, "1.1"},
+	"go":        {0x2B, "1.1"},
+	"head":      {0x2C, "1.1"},
+	"i":         {0x2D, "1.1"},
+	"img":       {0x2E, "1.1"},
+	"input":     {0x2F, "1.1"},
+	"meta":      {0x30, "1.1"},
+	"noop":      {0x31, "1.1"},
+	"prev":      {0x32, "1.1"},
+	"onevent":   {0x33, "1.1"},
+	"optgroup":  {0x34, "1.1"},
+	"option":    {0x35, "1.1"},
+	"refresh":   {0x36, "1.1"},
+	"select":    {0x37, "1.1"},
+	"small":     {0x38, "1.1"},
+	"strong":    {0x39, "1.1"},
+	"template":  {0x3B, "1.1"},
+	"timer":     {0x3C, "1.1"},
+	"u":         {0x3D, "1.1"},
+	"setvar":    {0x3E, "1.1"},
+	"wml":       {0x3F, "1.1"},
+}
+
+// attrStart is an attribute start token, it encodes the name and optionally
+// the start of the value
+type attrStart struct {
+	token   byte
+	name    string
+	prefix  string
+	version string
+}
+
+var attrStarts = []attrStart{
+	{0x05, "accept-charset", "", "1.1"},
+	{0x06, "align", "bottom", "1.1"},
+	{0x07, "align", "center", "1.1"},
+	{0x08, "align", "left", "1.1"},
+	{0x09, "align", "middle", "1.1"},
+	{0x0A, "align", "right", "1.1"},
+	{0x0B, "align", "top", "1.1"},
+	{0x0C, "alt", "", "1.1"},
+	{0x0D, "content", "", "1.1"},
+	{0x0F, "domain", "", "1.1"},
+	{0x10, "emptyok", "false", "1.1"},
+	{0x11, "emptyok", "true", "1.1"},
+	{0x12, "format", "", "1.1"},
+	{0x13, "height", "", "1.1"},
+	{0x14, "hspace", "", "1.1"},
+	{0x15, "ivalue", "", "1.1"},
+	{0x16, "iname", "", "1.1"},
+	{0x18, "label", "", "1.1"},
+	{0x19, "localsrc", "", "1.1"},
+	{0x1A, "maxlength", "", "1.1"},
+	{0x1B, "method", "get", "1.1"},
+	{0x1C, "method", "post", "1.1"},
+	{0x1D, "mode", "nowrap", "1.1"},
+	{0x1E, "mode", "wrap", "1.1"},
+	{0x1F, "multiple", "false", "1.1"},
+	{0x20, "multiple", "true", "1.1"},
+	{0x21, "name", "", "1.1"},
+	{0x22, "newcontext", "false", "1.1"},
+	{0x23, "newcontext", "true", "1.1"},
+	{0x24, "onpick", "", "1.1"},
+	{0x25, "onenterbackward", "", "1.1"},
+	{0x26, "onenterforward", "", "1.1"},
+	{0x27, "ontimer", "", "1.1"},
+	{0x28, "optional", "false", "1.1"},
+	{0x29, "optional", "true", "1.1"},
+	{0x2A, "path", "", "1.1"},
+	{0x2E, "scheme", "", "1.1"},
+	{0x2F, "sendreferer", "false", "1.1"},
+	{0x30, "sendreferer", "true", "1.1"},
+	{0x31, "size", "", "1.1"},
+	{0x32, "src", "", "1.1"},
+	{0x33, "ordered", "true", "1.1"},
+	{0x34, "ordered", "false", "1.1"},
+	{0x35, "tabindex", "", "1.1"},
+	{0x36, "title", "", "1.1"},
+	{0x37, "type", "", "1.1"},
+	{0x38, "type", "accept", "1.1"},
+	{0x39, "type", "delete", "1.1"},
+	{0x3A, "type", "help", "1.1"},
+	{0x3B, "type", "password", "1.1"},
+	{0x3C, "type", "onpick", "1.1"},
+	{0x3D, "type", "onenterbackward", "1.1"},
+	{0x3E, "type", "onenterforward", "1.1"},
+	{0x3F, "type", "ontimer", "1.1"},
+	{0x45, "type", "options", "1.1"},
+	{0x46, "type", "prev", "1.1"},
+	{0x47, "type", "reset", "1.1"},
+	{0x48, "type", "text", "1.1"},
+	{0x49, "type", "vnd.", "1.1"},
+	{0x4A, "href", "", "1.1"},
+	{0x4B, "href", "http://", "1.1"},
+	{0x4C, "href", "https://", "1.1"},
+	{0x4D, "value", "", "1.1"},
+	{0x4E, "vspace", "", "1.1"},
+	{0x4F, "width", "", "1.1"},
+	{0x50, "xml:lang", "", "1.1"},
+	{0x52, "align", "", "1.1"},
+	{0x53, "columns", "", "1.1"},
+	{0x54, "class", "", "1.1"},
+	{0x55, "id", "", "1.1"},
+	{0x56, "forua", "false", "1.1"},
+	{0x57, "forua", "true", "1.1"},
+	{0x58, "src", "http://", "1.1"},
+	{0x59, "src", "https://", "1.1"},
+	{0x5A, "http-equiv", "", "1.1"},
+	{0x5B, "http-equiv", "Content-Type", "1.1"},
+	{0x5C, "content", "application/vnd.wap.wmlc;charset=", "1.1"},
+	{0x5D, "http-equiv", "Expires", "1.1"},
+	{0x5E, "accesskey", "", "1.2"},
+	{0x5F, "enctype", "", "1.2"},
+	{0x60, "enctype", "application/x-www-form-urlencoded", "1.2"},
+	{0x61, "enctype", "multipart/form-data", "1.2"},
+	{0x62, "xml:space", "preserve", "1.3"},
+	{0x63, "xml:space", "default", "1.3"},
+	{0x64, "cache-control", "no-cache", "1.3"},
+}
+
+// attrValues are the attribute value tokens, they may appear anywhere in a value
+var attrValues = []struct {
+	token byte
+	value string
+}{
+	{0x85, ".com/"},
+	{0x86, ".edu/"},
+	{0x87, ".net/"},
+	{0x88, ".org/"},
+	{0x89, "accept"},
+	{0x8A, "bottom"},
+	{0x8B, "clear"},
+	{0x8C, "delete"},
+	{0x8D, "help"},
+	{0x8E, "http://"},
+	{0x8F, "http://www."},
+	{0x90, "https://"},
+	{0x91, "https://www."},
+	{0x93, "middle"},
+	{0x94, "nowrap"},
+	{0x95, "onpick"},
+	{0x96, "onenterbackward"},
+	{0x97, "onenterforward"},
+	{0x98, "ontimer"},
+	{0x99, "options"},
+	{0x9A, "password"},
+	{0x9B, "reset"},
+	{0x9D, "text"},
+	{0x9E, "top"},
+	{0x9F, "unknown"},
+	{0xA0, "wrap"},
+	{0xA1, "www."},
+}
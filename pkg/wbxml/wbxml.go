@@ -0,0 +1,332 @@
+// Package wbxml compiles WML decks into WAP Binary XML (WBXML) the way a WAP
+// gateway would, so WAP 1.x phones can be served without a Kannel in between
+package wbxml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/charset"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+)
+
+// ContentType is the MIME type of compiled WML
+const ContentType = "application/vnd.wap.wmlc"
+
+type Options struct {
+	// Charset of the strings in the output, "utf-8" (default), "iso-8859-1" or "us-ascii"
+	Charset string
+}
+
+// minimal string length worth putting in the string table
+const minTableString = 4
+
+type encoder struct {
+	version string
+	latin1  bool
+	// ascii is set for us-ascii, latin1 is set with it as it is a subset
+	ascii bool
+
+	collecting bool
+	counts     map[string]int
+	table      map[string]int
+	strtbl     bytes.Buffer
+
+	buf bytes.Buffer
+}
+
+// Encode compiles the document to WBXML
+func Encode(d *wml.Document, opts Options) ([]byte, error) {
+	e := &encoder{
+		version: d.Version(),
+		latin1:  strings.EqualFold(opts.Charset, "iso-8859-1") || strings.EqualFold(opts.Charset, "us-ascii"),
+		ascii:   strings.EqualFold(opts.Charset, "us-ascii"),
+		counts:  map[string]int{},
+		table:   map[string]int{},
+	}
+
+	// first pass finds the strings that occur more than once for the string table
+	e.collecting = true
+	if err := e.element(d.Root, false); err != nil {
+		return nil, err
+	}
+	for s, n := range e.counts {
+		if n > 1 && len(s) >= minTableString {
+			e.table[s] = -1
+		}
+	}
+	e.collecting = false
+	e.buf.Reset()
+
+	if err := e.element(d.Root, false); err != nil {
+		return nil, err
+	}
+
+	out := bytes.Buffer{}
+	switch e.version {
+	case "1.3":
+		out.WriteByte(0x03)
+		writeMultiByte(&out, publicIDWML13)
+	case "1.2":
+		out.WriteByte(0x02)
+		writeMultiByte(&out, publicIDWML12)
+	default:
+		out.WriteByte(0x01)
+		writeMultiByte(&out, publicIDWML11)
+	}
+	switch {
+	case e.ascii:
+		writeMultiByte(&out, MIBUSASCII)
+	case e.latin1:
+		writeMultiByte(&out, MIBISO8859_1)
+	default:
+		writeMultiByte(&out, MIBUTF8)
+	}
+	writeMultiByte(&out, uint32(e.strtbl.Len()))
+	out.Write(e.strtbl.Bytes())
+	out.Write(e.buf.Bytes())
+	return out.Bytes(), nil
+}
+
+func writeMultiByte(buf *bytes.Buffer, n uint32) {
+	var b [5]byte
+	i := len(b) - 1
+	b[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		b[i] = byte(n&0x7f) | 0x80
+	}
+	buf.Write(b[i:])
+}
+
+func (e *encoder) element(n *wml.Node, pre bool) error {
+	tag, ok := tags[n.Name]
+	if !ok || tag.version > e.version {
+		return fmt.Errorf("wbxml: element <%s> is not part of WML %s", n.Name, e.version)
+	}
+	pre = pre || n.Name == "pre"
+
+	token := tag.token
+	if len(n.Attrs) > 0 {
+		token |= tagHasAttributes
+	}
+	if len(n.Children) > 0 {
+		token |= tagHasContent
+	}
+	e.buf.WriteByte(token)
+
+	if len(n.Attrs) > 0 {
+		for _, a := range n.Attrs {
+			if err := e.attribute(a); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte(tokenEnd)
+	}
+
+	if len(n.Children) > 0 {
+		for _, child := range n.Children {
+			if child.Type == wml.TextNode {
+				text := child.Text
+				if !pre {
+					text = collapseSpace(text)
+				}
+				e.value(text, false)
+				continue
+			}
+			if err := e.element(child, pre); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte(tokenEnd)
+	}
+	return nil
+}
+
+// collapseSpace turns runs of whitespace into a single space like the phone would when rendering
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (e *encoder) attribute(a wml.Attr) error {
+	var best *attrStart
+	for i, s := range attrStarts {
+		if s.name != a.Name || s.version > e.version || !strings.HasPrefix(a.Value, s.prefix) {
+			continue
+		}
+		if best == nil || len(s.prefix) > len(best.prefix) {
+			best = &attrStarts[i]
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("wbxml: attribute %s is not part of WML %s", a.Name, e.version)
+	}
+
+	e.buf.WriteByte(best.token)
+	e.value(a.Value[len(best.prefix):], true)
+	return nil
+}
+
+// value writes text or an attribute value, replacing WML variables with
+// extension tokens and, in attributes, known substrings with value tokens
+func (e *encoder) value(s string, attr bool) {
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			e.str(literal.String())
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		if s[i] == '$' {
+			name, conv, n := parseVariable(s[i:])
+			if n == 0 {
+				// "$$" and stray dollars are a literal dollar
+				literal.WriteByte('$')
+				i++
+				if strings.HasPrefix(s[i:], "$") {
+					i++
+				}
+				continue
+			}
+			flush()
+			switch conv {
+			case "e", "escape":
+				e.buf.WriteByte(tokenExtI0)
+			case "u", "unesc":
+				e.buf.WriteByte(tokenExtI1)
+			default:
+				e.buf.WriteByte(tokenExtI2)
+			}
+			e.termstr(name)
+			i += n
+			continue
+		}
+
+		if attr {
+			if token, n := matchValueToken(s[i:]); n > 0 {
+				flush()
+				e.buf.WriteByte(token)
+				i += n
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		literal.WriteString(s[i : i+size])
+		i += size
+	}
+	flush()
+}
+
+func matchValueToken(s string) (byte, int) {
+	var token byte
+	n := 0
+	for _, v := range attrValues {
+		if len(v.value) > n && strings.HasPrefix(s, v.value) {
+			token, n = v.token, len(v.value)
+		}
+	}
+	return token, n
+}
+
+// parseVariable parses $name, $(name) or $(name:conv) at the start of s,
+// it returns the consumed length, 0 if s does not start with a variable
+func parseVariable(s string) (name, conv string, n int) {
+	if strings.HasPrefix(s, "$(") {
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return "", "", 0
+		}
+		name, conv, _ = strings.Cut(s[2:end], ":")
+		if !isVarName(name) {
+			return "", "", 0
+		}
+		return name, strings.ToLower(conv), end + 1
+	}
+
+	end := 1
+	for end < len(s) && isVarChar(s[end], end == 1) {
+		end++
+	}
+	if end == 1 {
+		return "", "", 0
+	}
+	return s[1:end], "", end
+}
+
+func isVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isVarChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isVarChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (e *encoder) encodeString(s string) []byte {
+	if !e.latin1 {
+		return []byte(s)
+	}
+	limit := rune(0xff)
+	if e.ascii {
+		limit = 0x7f
+	}
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > limit {
+			out = append(out, charset.Transliterate(r)...)
+			continue
+		}
+		out = append(out, byte(r))
+	}
+	return out
+}
+
+// str writes an inline string, or a reference when it is in the string table
+func (e *encoder) str(s string) {
+	if e.collecting {
+		e.counts[s]++
+	}
+	if offset, ok := e.table[s]; ok {
+		if offset < 0 {
+			offset = e.strtbl.Len()
+			e.table[s] = offset
+			e.strtbl.Write(e.encodeString(s))
+			e.strtbl.WriteByte(0)
+		}
+		e.buf.WriteByte(tokenStrT)
+		writeMultiByte(&e.buf, uint32(offset))
+		return
+	}
+	e.buf.WriteByte(tokenStrI)
+	e.termstr(s)
+}
+
+func (e *encoder) termstr(s string) {
+	e.buf.Write(e.encodeString(s))
+	e.buf.WriteByte(0)
+}
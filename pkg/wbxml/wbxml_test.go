@@ -0,0 +1,212 @@
+package wbxml
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+)
+
+const (
+	docType11 = `<!DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.1//EN" "http://www.wapforum.org/DTD/wml_1.1.xml">`
+	docType13 = `<!DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.3//EN" "http://www.wapforum.org/DTD/wml13.dtd">`
+)
+
+var decks = []struct {
+	name string
+	src  string
+}{
+	{"wml 1.1", `<?xml version="1.0"?>` + docType11 + `
+<wml>
+<head><meta http-equiv="Cache-Control" content="max-age=0" forua="true"/></head>
+<template><do type="prev" label="Back"><prev/></do></template>
+<card id="home" title="Café Liège" newcontext="true">
+<p align="center" xml:lang="fr">Noël à Liège, garçon &amp; crème<br/> <a href="http://www.example.com/news?id=1&amp;page=2">Nieuws</a> <img src="https://example.org/logo.wbmp" alt="logo" width="20" height="10"/></p>
+<p>Zoek: <input name="q" title="Zoek" emptyok="false" maxlength="30"/> <select name="kind" multiple="false"><option value="a" onpick="#a">A</option><option value="b">B</option></select> <anchor>Ga<go href="/search?q=$(q:e)&amp;k=$(kind)" method="post"><postfield name="k" value="$(kind)"/></go></anchor> Prijs $$5</p>
+<onevent type="ontimer"><refresh><setvar name="q" value=""/></refresh></onevent>
+<timer value="100"/>
+</card>
+<card id="table"><p><table columns="2"><tr><td>é</td><td>ë</td></tr></table></p></card>
+</wml>`},
+	{"wml 1.3", `<?xml version="1.0"?>` + docType13 + `
+<wml>
+<card id="c1" title="Météo">
+<p xml:lang="nl">Morgen <b>zon</b>, <i>wolken</i> <u>en</u> <em>regen</em> <strong>!</strong> <small>s</small> <big>B</big></p>
+<pre xml:space="preserve">  keep
+  this</pre>
+<p><a href="#c2" accesskey="1">Verder</a></p>
+<do type="accept" label="OK"><go href="/form" method="post" enctype="application/x-www-form-urlencoded" cache-control="no-cache"/></do>
+</card>
+<card id="c2"><p><fieldset title="f"><input name="n" type="password"/></fieldset></p></card>
+</wml>`},
+}
+
+// serialize renders a document with the encoding left out, Decode only
+// knows the charset of the binary deck
+func serialize(t *testing.T, d *wml.Document) string {
+	t.Helper()
+	d.Encoding = ""
+	return string(d.Bytes())
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, deck := range decks {
+		for _, cs := range []string{"utf-8", "iso-8859-1"} {
+			t.Run(deck.name+" "+cs, func(t *testing.T) {
+				doc, err := wml.Parse(strings.NewReader(deck.src))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := Encode(doc, Options{Charset: cs})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := Decode(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Encoding != cs {
+					t.Errorf("charset %q, want %q", got.Encoding, cs)
+				}
+				if got.Version() != doc.Version() {
+					t.Errorf("version %s, want %s", got.Version(), doc.Version())
+				}
+				if want := serialize(t, doc); serialize(t, got) != want {
+					t.Errorf("round trip changed the deck\ngot:\n%s\nwant:\n%s", serialize(t, got), want)
+				}
+			})
+		}
+	}
+}
+
+func TestCharset(t *testing.T) {
+	doc, err := wml.Parse(strings.NewReader(`<?xml version="1.0"?>` + docType11 + `<wml><card><p>é€</p></card></wml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latin1, err := Encode(doc, Options{Charset: "iso-8859-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(latin1, []byte("\xe9EUR\x00")) {
+		t.Errorf("iso-8859-1 deck %q does not hold é as one byte and € transliterated", latin1)
+	}
+
+	utf8, err := Encode(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(utf8, []byte("é€\x00")) {
+		t.Errorf("utf-8 deck %q does not hold the text as is", utf8)
+	}
+
+	ascii, err := Encode(doc, Options{Charset: "us-ascii"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(ascii, []byte("eEUR\x00")) {
+		t.Errorf("us-ascii deck %q does not hold é and € transliterated", ascii)
+	}
+	decoded, err := Decode(ascii)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Encoding != "us-ascii" {
+		t.Errorf("us-ascii deck decodes as %s", decoded.Encoding)
+	}
+}
+
+// tableDeck builds a deck that uses the tag with an attribute start token,
+// the encoder does not care whether they belong together
+func tableDeck(version, tag string, attr wml.Attr) *wml.Document {
+	docType := strings.Trim(docType11, "<!>")
+	if version == "1.3" {
+		docType = strings.Trim(docType13, "<!>")
+	}
+	el := wml.NewElement(tag, attr)
+	el.Children = append(el.Children, wml.NewText("x"))
+	return &wml.Document{DocType: docType, Root: el}
+}
+
+func TestTables(t *testing.T) {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, version := range []string{"1.1", "1.3"} {
+		for _, name := range names {
+			doc := tableDeck(version, name, wml.Attr{Name: "id", Value: "i"})
+			data, err := Encode(doc, Options{})
+			if tags[name].version > version {
+				if err == nil {
+					t.Errorf("WML %s: <%s> was encoded", version, name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("WML %s: <%s>: %v", version, name, err)
+				continue
+			}
+			got, err := Decode(data)
+			if err != nil || got.Root.Name != name {
+				t.Errorf("WML %s: <%s> decoded as %v, %v", version, name, got, err)
+			}
+		}
+
+		for _, start := range attrStarts {
+			if start.version > version {
+				continue
+			}
+			attr := wml.Attr{Name: start.name, Value: start.prefix + "v"}
+			data, err := Encode(tableDeck(version, "p", attr), Options{})
+			if err != nil {
+				t.Errorf("WML %s: %s=%q: %v", version, attr.Name, attr.Value, err)
+				continue
+			}
+			got, err := Decode(data)
+			if err != nil {
+				t.Errorf("WML %s: %s=%q: %v", version, attr.Name, attr.Value, err)
+				continue
+			}
+			if len(got.Root.Attrs) != 1 || got.Root.Attrs[0] != attr {
+				t.Errorf("WML %s: %s=%q decoded as %v", version, attr.Name, attr.Value, got.Root.Attrs)
+			}
+		}
+
+		for _, v := range attrValues {
+			attr := wml.Attr{Name: "href", Value: "/" + v.value}
+			data, err := Encode(tableDeck(version, "a", attr), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(data, []byte{v.token}) {
+				t.Errorf("WML %s: %q is not encoded as %#x", version, v.value, v.token)
+			}
+			got, err := Decode(data)
+			if err != nil || got.Root.Attrs[0] != attr {
+				t.Errorf("WML %s: %q decoded as %v, %v", version, attr.Value, got, err)
+			}
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	doc, err := wml.Parse(strings.NewReader(decks[0].src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encode(doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		if _, err := Decode(data[:i]); err == nil {
+			t.Errorf("decoding the first %d of %d bytes did not fail", i, len(data))
+		}
+	}
+}
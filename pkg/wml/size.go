@@ -0,0 +1,36 @@
+package wml
+
+import "strings"
+
+// EstimateSize approximates the size of the deck once a gateway compiled it to WBXML:
+// one byte per tag and attribute name, inline strings for all values and text
+func EstimateSize(d *Document) int {
+	// version, public ID, charset and string table length
+	return 4 + estimateNode(d.Root)
+}
+
+func estimateNode(n *Node) int {
+	if n.Type == TextNode {
+		text := strings.Join(strings.Fields(n.Text), " ")
+		if text == "" {
+			return 0
+		}
+		// STR_I, the string and its terminator
+		return len(text) + 2
+	}
+
+	size := 1
+	if len(n.Attrs) > 0 {
+		for _, a := range n.Attrs {
+			size += 1 + len(a.Value) + 2
+		}
+		size++ // END of the attribute list
+	}
+	if len(n.Children) > 0 {
+		for _, child := range n.Children {
+			size += estimateNode(child)
+		}
+		size++ // END of the content
+	}
+	return size
+}
@@ -0,0 +1,282 @@
+package wml
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Measure returns the size of a deck as the phone will receive it
+type Measure func(*Document) int
+
+// PageURL returns the URL the continuation deck with index page will be served at
+type PageURL func(page int) string
+
+// MoreLabel is the label of the <do> that leads to the next part of a split card
+var MoreLabel = "More"
+
+// attributes that can hold links to other cards
+var linkAttrs = []string{"href", "onenterforward", "onenterbackward", "ontimer", "onpick"}
+
+// Split breaks a deck that is larger than maxSize into decks that fit.
+// Cards that are too large on their own are cut into parts chained by a "More" <do>,
+// links between cards that end up in different decks are rewritten to pageURL.
+// It returns nil when the deck fits as is.
+func Split(d *Document, maxSize int, measure Measure, pageURL PageURL) []*Document {
+	if measure(d) <= maxSize {
+		return nil
+	}
+
+	s := splitter{doc: d, maxSize: maxSize, measure: measure, pageURL: pageURL}
+	for _, n := range d.Root.Children {
+		if n.Type == ElementNode && n.Name != "card" {
+			s.shared = append(s.shared, n)
+		}
+	}
+
+	var cards []*Node
+	for i, card := range d.Root.Elements("card") {
+		card = card.Clone()
+		if id, _ := card.Attr("id"); id == "" {
+			card.SetAttr("id", fmt.Sprintf("c%d", i+1))
+		}
+		if s.fits(card) {
+			cards = append(cards, card)
+		} else {
+			cards = append(cards, s.splitCard(card)...)
+		}
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+
+	// pack cards into decks, first come first served
+	pages := [][]*Node{{cards[0]}}
+	for _, card := range cards[1:] {
+		last := len(pages) - 1
+		if s.fits(append(append([]*Node{}, pages[last]...), card)...) {
+			pages[last] = append(pages[last], card)
+		} else {
+			pages = append(pages, []*Node{card})
+		}
+	}
+
+	cardPage := map[string]int{}
+	for p, page := range pages {
+		for _, card := range page {
+			id, _ := card.Attr("id")
+			cardPage[id] = p
+		}
+	}
+
+	docs := make([]*Document, len(pages))
+	for p, page := range pages {
+		docs[p] = s.deck(page, func(id string) (int, bool) {
+			target, ok := cardPage[id]
+			return target, ok && target != p
+		})
+	}
+	return docs
+}
+
+type splitter struct {
+	doc     *Document
+	shared  []*Node
+	maxSize int
+	measure Measure
+	pageURL PageURL
+}
+
+// deck builds a document of the shared head/template and cards, rewriting links
+// for which elsewhere reports another page
+func (s *splitter) deck(cards []*Node, elsewhere func(id string) (int, bool)) *Document {
+	root := NewElement(s.doc.Root.Name, s.doc.Root.Attrs...)
+	for _, n := range s.shared {
+		root.Children = append(root.Children, n.Clone())
+	}
+	for _, card := range cards {
+		card = card.Clone()
+		card.Walk(func(n *Node) {
+			for i, a := range n.Attrs {
+				if !isLinkAttr(a.Name) || !strings.HasPrefix(a.Value, "#") {
+					continue
+				}
+				if page, ok := elsewhere(a.Value[1:]); ok {
+					n.Attrs[i].Value = s.pageURL(page) + a.Value
+				}
+			}
+		})
+		root.Children = append(root.Children, card)
+	}
+	return &Document{Encoding: s.doc.Encoding, DocType: s.doc.DocType, Root: root}
+}
+
+// size measures the cards as one deck, assuming every link points to another deck
+func (s *splitter) size(cards ...*Node) int {
+	return s.measure(s.deck(cards, func(string) (int, bool) { return 99, true }))
+}
+
+func (s *splitter) fits(cards ...*Node) bool {
+	return s.size(cards...) <= s.maxSize
+}
+
+func isLinkAttr(name string) bool {
+	for _, a := range linkAttrs {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCard cuts a card into parts that each fit on their own
+func (s *splitter) splitCard(card *Node) []*Node {
+	var first, backs, last, content []*Node
+	for _, n := range card.Children {
+		switch {
+		case n.Type == ElementNode && (n.Name == "onevent" || n.Name == "timer"):
+			first = append(first, n)
+		case n.Type == ElementNode && n.Name == "do":
+			if t, _ := n.Attr("type"); t == "prev" {
+				backs = append(backs, n)
+			} else {
+				last = append(last, n)
+			}
+		default:
+			content = append(content, n)
+		}
+	}
+
+	id, _ := card.Attr("id")
+	part := func(i int, nodes []*Node) *Node {
+		p := NewElement("card", card.Attrs...)
+		if i > 0 {
+			p.SetAttr("id", fmt.Sprintf("%s-%d", id, i+1))
+		}
+		if i == 0 {
+			p.Children = append(p.Children, first...)
+		}
+		p.Children = append(p.Children, nodes...)
+		p.Children = append(p.Children, backs...)
+		return p
+	}
+	// while packing we do not know which part is the last, reserve room for both endings
+	partSize := func(i int, nodes []*Node) int {
+		p := part(i, nodes)
+		p.Children = append(p.Children, last...)
+		p.Children = append(p.Children, moreDo(id))
+		return s.size(p)
+	}
+	fitsPart := func(i int, nodes []*Node) bool {
+		return partSize(i, nodes) <= s.maxSize
+	}
+
+	// paragraphs that are too large on their own are cut into smaller ones first
+	var pieces []*Node
+	for _, n := range content {
+		if n.Type == ElementNode && n.Name == "p" && !fitsPart(1, []*Node{n}) {
+			pieces = append(pieces, s.splitParagraph(n, func(p *Node) int { return partSize(1, []*Node{p}) })...)
+		} else {
+			pieces = append(pieces, n)
+		}
+	}
+
+	var groups [][]*Node
+	var current []*Node
+	for _, piece := range pieces {
+		candidate := append(append([]*Node{}, current...), piece)
+		if len(current) > 0 && !fitsPart(len(groups), candidate) {
+			groups = append(groups, current)
+			current = []*Node{piece}
+			continue
+		}
+		current = candidate
+	}
+	groups = append(groups, current)
+
+	parts := make([]*Node, len(groups))
+	for i, g := range groups {
+		parts[i] = part(i, g)
+	}
+	for i, p := range parts {
+		if i == len(parts)-1 {
+			p.Children = append(p.Children, last...)
+			continue
+		}
+		next, _ := parts[i+1].Attr("id")
+		p.Children = append(p.Children, moreDo(next))
+	}
+	return parts
+}
+
+func moreDo(target string) *Node {
+	do := NewElement("do", Attr{"type", "accept"}, Attr{"label", MoreLabel})
+	do.Children = []*Node{NewElement("go", Attr{"href", "#" + target})}
+	return do
+}
+
+// splitParagraph cuts a paragraph between words and inline elements into
+// paragraphs that fit, size measures a deck holding just one paragraph.
+// Every word is measured once on its own instead of measuring the growing
+// paragraph after each word. That never underestimates, words glued into
+// one text string share the overhead each of them was measured with
+func (s *splitter) splitParagraph(p *Node, size func(*Node) int) []*Node {
+	var units []*Node
+	for _, child := range p.Children {
+		if child.Type == TextNode {
+			for _, word := range splitWords(child.Text) {
+				units = append(units, NewText(word))
+			}
+		} else {
+			units = append(units, child)
+		}
+	}
+
+	build := func(nodes []*Node) *Node {
+		out := NewElement("p", p.Attrs...)
+		for _, n := range nodes {
+			// glue words back together into a single text node
+			if n.Type == TextNode && len(out.Children) > 0 && out.Children[len(out.Children)-1].Type == TextNode {
+				prev := out.Children[len(out.Children)-1]
+				out.Children[len(out.Children)-1] = NewText(prev.Text + n.Text)
+				continue
+			}
+			out.Children = append(out.Children, n)
+		}
+		return out
+	}
+
+	empty := size(build(nil))
+	var out []*Node
+	var current []*Node
+	used := empty
+	for _, u := range units {
+		cost := size(build([]*Node{u})) - empty
+		if len(current) > 0 && used+cost > s.maxSize {
+			out = append(out, build(current))
+			current, used = nil, empty
+		}
+		current = append(current, u)
+		used += cost
+	}
+	return append(out, build(current))
+}
+
+// splitWords cuts text after every run of whitespace, keeping the whitespace
+func splitWords(text string) []string {
+	var words []string
+	start := 0
+	inSpace := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if inSpace && !space {
+			words = append(words, text[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
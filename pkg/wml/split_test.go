@@ -0,0 +1,176 @@
+package wml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const splitDeck = `<?xml version="1.0"?>
+<!DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML 1.1//EN" "http://www.wapforum.org/DTD/wml_1.1.xml">
+<wml>
+<template><do type="prev" label="Back"><prev/></do></template>
+<card id="home" title="Home">
+<p><a href="#news">News</a><br/><a href="#weather">Weather</a><br/><a href="#home">Top</a></p>
+</card>
+<card id="news" title="News" onenterforward="#weather">
+<p>%s</p>
+<p><a href="#home">Home</a></p>
+<do type="accept" label="Weather"><go href="#weather"/></do>
+</card>
+<card id="weather" title="Weather">
+<p>Sunny with a chance of rain. <a href="#news">News</a></p>
+</card>
+</wml>`
+
+func parseSplitDeck(t *testing.T, words int) *Document {
+	t.Helper()
+	text := make([]string, words)
+	for i := range text {
+		text[i] = fmt.Sprintf("word%d", i)
+	}
+	d, err := Parse(strings.NewReader(fmt.Sprintf(splitDeck, strings.Join(text, " "))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func testPageURL(page int) string {
+	return fmt.Sprintf("/more?id=x&p=%d", page)
+}
+
+func TestSplitFits(t *testing.T) {
+	d := parseSplitDeck(t, 5)
+	if pages := Split(d, EstimateSize(d), EstimateSize, testPageURL); pages != nil {
+		t.Errorf("Split of a deck that fits returned %d pages", len(pages))
+	}
+}
+
+var pageLink = regexp.MustCompile(`^/more\?id=x&p=(\d+)#(.+)$`)
+
+func TestSplit(t *testing.T) {
+	for _, maxSize := range []int{250, 400, 800} {
+		t.Run(fmt.Sprint(maxSize), func(t *testing.T) {
+			d := parseSplitDeck(t, 200)
+			pages := Split(d, maxSize, EstimateSize, testPageURL)
+			if len(pages) < 2 {
+				t.Fatalf("Split returned %d pages for a %d byte deck", len(pages), EstimateSize(d))
+			}
+
+			cardPage := map[string]int{}
+			for p, page := range pages {
+				if size := EstimateSize(page); size > maxSize {
+					t.Errorf("page %d is %d bytes, limit is %d", p, size, maxSize)
+				}
+				for _, v := range Validate(page) {
+					t.Errorf("page %d: %s", p, v)
+				}
+				if len(page.Root.Elements("template")) != 1 {
+					t.Errorf("page %d lost the shared <template>", p)
+				}
+				for _, card := range page.Root.Elements("card") {
+					id, _ := card.Attr("id")
+					cardPage[id] = p
+				}
+			}
+			for _, id := range []string{"home", "news", "weather"} {
+				if _, ok := cardPage[id]; !ok {
+					t.Errorf("card %s is on no page", id)
+				}
+			}
+
+			// links within a page stay fragments, others point at the page holding the card
+			for p, page := range pages {
+				page.Root.Walk(func(n *Node) {
+					for _, a := range n.Attrs {
+						if !isLinkAttr(a.Name) {
+							continue
+						}
+						if target, ok := strings.CutPrefix(a.Value, "#"); ok {
+							if cardPage[target] != p {
+								t.Errorf("page %d links to #%s which is on page %d", p, target, cardPage[target])
+							}
+							continue
+						}
+						m := pageLink.FindStringSubmatch(a.Value)
+						if m == nil {
+							t.Errorf("page %d has unexpected link %s=%q", p, a.Name, a.Value)
+							continue
+						}
+						if target := m[2]; m[1] != fmt.Sprint(cardPage[target]) || cardPage[target] == p {
+							t.Errorf("page %d links to %s, card %s is on page %d", p, a.Value, target, cardPage[target])
+						}
+					}
+				})
+			}
+
+			// cutting the long paragraph keeps every word in order
+			var words []string
+			for _, page := range pages {
+				page.Root.Walk(func(n *Node) {
+					if n.Type == TextNode {
+						for _, w := range strings.Fields(n.Text) {
+							if strings.HasPrefix(w, "word") {
+								words = append(words, w)
+							}
+						}
+					}
+				})
+			}
+			if len(words) != 200 {
+				t.Fatalf("pages hold %d words, want 200", len(words))
+			}
+			for i, w := range words {
+				if w != fmt.Sprintf("word%d", i) {
+					t.Fatalf("word %d is %s", i, w)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitCardChain(t *testing.T) {
+	d := parseSplitDeck(t, 200)
+	pages := Split(d, 250, EstimateSize, testPageURL)
+
+	// follow the More links from the news card through all its parts
+	cards := map[string]*Node{}
+	for _, page := range pages {
+		for _, card := range page.Root.Elements("card") {
+			id, _ := card.Attr("id")
+			cards[id] = card
+		}
+	}
+
+	id := "news"
+	for parts := 1; ; parts++ {
+		card := cards[id]
+		if card == nil {
+			t.Fatalf("part %d of the news card, #%s, does not exist", parts, id)
+		}
+		if title, _ := card.Attr("title"); title != "News" {
+			t.Errorf("part %s has title %q", id, title)
+		}
+
+		var next string
+		for _, do := range card.Elements("do") {
+			if label, _ := do.Attr("label"); label == MoreLabel {
+				href, _ := do.Elements("go")[0].Attr("href")
+				next = href[strings.Index(href, "#")+1:]
+			}
+		}
+		if next == "" {
+			if parts < 2 {
+				t.Errorf("the news card was not cut into parts")
+			}
+			// the accept <do> of the original card ends up on the last part
+			if len(card.Elements("do")) != 1 {
+				t.Errorf("last part %s has %d <do>, want the original one", id, len(card.Elements("do")))
+			}
+			break
+		}
+		id = next
+	}
+}
@@ -0,0 +1,305 @@
+package wml
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Violation is a place where a deck breaks the WML DTD
+type Violation struct {
+	// Path locates the element, e.g. wml/card[2]/p[1]
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+type elementRule struct {
+	// children lists the allowed child elements, "#text" allows character data
+	children []string
+	attrs    []string
+	required []string
+	// enums restricts attribute values
+	enums   map[string][]string
+	version string
+}
+
+var (
+	textElements   = []string{"#text", "em", "strong", "b", "i", "u", "big", "small"}
+	flowElements   = append(slices.Clone(textElements), "br", "img", "anchor", "a", "table")
+	fieldsElements = append(slices.Clone(flowElements), "input", "select", "fieldset")
+	taskElements   = []string{"go", "prev", "noop", "refresh"}
+	boolValues     = []string{"true", "false"}
+)
+
+// coreAttrs are allowed on every element
+var coreAttrs = []string{"id", "class", "xml:lang"}
+
+// rules follow the WML 1.1 DTD, with the WML 1.2 and 1.3 additions marked by version
+var rules = map[string]elementRule{
+	"wml":      {children: []string{"head", "template", "card"}},
+	"head":     {children: []string{"access", "meta"}},
+	"template": {children: []string{"do", "onevent"}, attrs: []string{"onenterforward", "onenterbackward", "ontimer"}},
+	"access":   {attrs: []string{"domain", "path"}},
+	"meta": {
+		attrs:    []string{"http-equiv", "name", "forua", "content", "scheme"},
+		required: []string{"content"},
+		enums:    map[string][]string{"forua": boolValues},
+	},
+	"card": {
+		children: []string{"onevent", "timer", "do", "p", "pre"},
+		attrs:    []string{"title", "newcontext", "ordered", "onenterforward", "onenterbackward", "ontimer"},
+		enums:    map[string][]string{"newcontext": boolValues, "ordered": boolValues},
+	},
+	"do": {
+		children: taskElements,
+		attrs:    []string{"type", "label", "name", "optional"},
+		required: []string{"type"},
+		enums:    map[string][]string{"optional": boolValues},
+	},
+	"onevent": {
+		children: taskElements,
+		attrs:    []string{"type"},
+		required: []string{"type"},
+		enums:    map[string][]string{"type": {"onpick", "onenterforward", "onenterbackward", "ontimer"}},
+	},
+	"postfield": {attrs: []string{"name", "value"}, required: []string{"name", "value"}},
+	"go": {
+		children: []string{"postfield", "setvar"},
+		attrs:    []string{"href", "sendreferer", "method", "accept-charset", "enctype", "cache-control"},
+		required: []string{"href"},
+		enums:    map[string][]string{"sendreferer": boolValues, "method": {"get", "post"}},
+	},
+	"prev":    {children: []string{"setvar"}},
+	"refresh": {children: []string{"setvar"}},
+	"noop":    {},
+	"setvar":  {attrs: []string{"name", "value"}, required: []string{"name", "value"}},
+	"select": {
+		children: []string{"optgroup", "option"},
+		attrs:    []string{"title", "name", "value", "iname", "ivalue", "multiple", "tabindex"},
+		enums:    map[string][]string{"multiple": boolValues},
+	},
+	"optgroup": {children: []string{"optgroup", "option"}, attrs: []string{"title"}},
+	"option":   {children: []string{"#text", "onevent"}, attrs: []string{"value", "title", "onpick"}},
+	"input": {
+		attrs:    []string{"name", "type", "value", "format", "emptyok", "size", "maxlength", "tabindex", "title", "accesskey"},
+		required: []string{"name"},
+		enums:    map[string][]string{"type": {"text", "password"}, "emptyok": boolValues},
+	},
+	"fieldset": {children: append(slices.Clone(fieldsElements), "do"), attrs: []string{"title"}},
+	"timer":    {attrs: []string{"name", "value"}, required: []string{"value"}},
+	"img": {
+		attrs:    []string{"alt", "src", "localsrc", "vspace", "hspace", "align", "height", "width"},
+		required: []string{"alt", "src"},
+		enums:    map[string][]string{"align": {"top", "middle", "bottom"}},
+	},
+	"anchor": {children: []string{"#text", "br", "img", "go", "prev", "refresh"}, attrs: []string{"title", "accesskey"}},
+	"a":      {children: []string{"#text", "br", "img"}, attrs: []string{"href", "title", "accesskey"}, required: []string{"href"}},
+	"table": {
+		children: []string{"tr"},
+		attrs:    []string{"title", "align", "columns"},
+		required: []string{"columns"},
+	},
+	"tr":     {children: []string{"td"}},
+	"td":     {children: append(slices.Clone(textElements), "br", "img", "anchor", "a")},
+	"em":     {children: flowElements},
+	"strong": {children: flowElements},
+	"b":      {children: flowElements},
+	"i":      {children: flowElements},
+	"u":      {children: flowElements},
+	"big":    {children: flowElements},
+	"small":  {children: flowElements},
+	"p": {
+		children: append(slices.Clone(fieldsElements), "do"),
+		attrs:    []string{"align", "mode"},
+		enums:    map[string][]string{"align": {"left", "right", "center"}, "mode": {"wrap", "nowrap"}},
+	},
+	"br":  {},
+	"pre": {children: append(slices.Clone(fieldsElements), "do"), attrs: []string{"xml:space"}, version: "1.3"},
+}
+
+// attrVersions lists attributes that only exist from a later WML version on
+var attrVersions = map[string]string{
+	"accesskey":     "1.2",
+	"enctype":       "1.2",
+	"cache-control": "1.3",
+	"xml:space":     "1.3",
+}
+
+// varConversions are the escaping modes a $(name:conv) reference may ask for
+var varConversions = []string{"escape", "noesc", "unesc", "e", "n", "u"}
+
+func isVarStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isVarChar(c byte) bool {
+	return isVarStart(c) || c >= '0' && c <= '9'
+}
+
+func validVarName(name string) bool {
+	if name == "" || !isVarStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isVarChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkVars reports the first malformed variable reference in s, phones
+// substitute $name, $(name) and $(name:conv) and a literal dollar is $$
+func checkVars(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			continue
+		}
+		i++
+		switch {
+		case i == len(s):
+			return "lone $ at the end, a dollar sign is written $$"
+		case s[i] == '$':
+		case s[i] == '(':
+			end := strings.IndexByte(s[i:], ')')
+			if end < 0 {
+				return fmt.Sprintf("unterminated variable reference %q", s[i-1:])
+			}
+			ref := s[i-1 : i+end+1]
+			name, conv, hasConv := strings.Cut(s[i+1:i+end], ":")
+			if !validVarName(name) {
+				return fmt.Sprintf("invalid variable name in %s", ref)
+			}
+			if hasConv && !slices.Contains(varConversions, strings.ToLower(conv)) {
+				return fmt.Sprintf("unknown conversion in %s, use escape, noesc or unesc", ref)
+			}
+			i += end
+		case isVarStart(s[i]):
+			for i+1 < len(s) && isVarChar(s[i+1]) {
+				i++
+			}
+		default:
+			return fmt.Sprintf("$ is not followed by a variable name in %q, a dollar sign is written $$", s)
+		}
+	}
+	return ""
+}
+
+// Validate checks the deck against the element and attribute rules of the WML
+// version in its DOCTYPE, it also reports links to cards that do not exist
+// and malformed variable references
+func Validate(d *Document) []Violation {
+	version := d.Version()
+	var violations []Violation
+	report := func(path, format string, args ...any) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if d.Root.Name != "wml" {
+		report(d.Root.Name, "root element must be <wml>")
+	}
+
+	ids := map[string]bool{}
+	var links []struct{ path, target string }
+
+	var walk func(n *Node, path string)
+	walk = func(n *Node, path string) {
+		rule, ok := rules[n.Name]
+		if !ok || rule.version > version {
+			report(path, "<%s> is not a WML %s element", n.Name, version)
+			return
+		}
+
+		for _, a := range n.Attrs {
+			if !slices.Contains(rule.attrs, a.Name) && !slices.Contains(coreAttrs, a.Name) {
+				report(path, "attribute %s is not allowed on <%s>", a.Name, n.Name)
+				continue
+			}
+			if v, ok := attrVersions[a.Name]; ok && v > version {
+				report(path, "attribute %s needs WML %s", a.Name, v)
+			}
+			if allowed, ok := rule.enums[a.Name]; ok && !slices.Contains(allowed, a.Value) && !strings.Contains(a.Value, "$") {
+				report(path, "attribute %s=%q must be one of %s", a.Name, a.Value, strings.Join(allowed, ", "))
+			}
+			if msg := checkVars(a.Value); msg != "" {
+				report(path, "attribute %s: %s", a.Name, msg)
+			}
+			if isLinkAttr(a.Name) && strings.HasPrefix(a.Value, "#") {
+				links = append(links, struct{ path, target string }{path, a.Value[1:]})
+			}
+		}
+		for _, req := range rule.required {
+			if _, ok := n.Attr(req); !ok {
+				report(path, "<%s> is missing required attribute %s", n.Name, req)
+			}
+		}
+		if n.Name == "card" {
+			if id, ok := n.Attr("id"); ok {
+				if ids[id] {
+					report(path, "duplicate card id %q", id)
+				}
+				ids[id] = true
+			}
+		}
+
+		counts := map[string]int{}
+		for _, child := range n.Children {
+			if child.Type == TextNode {
+				if msg := checkVars(child.Text); msg != "" {
+					report(path, "%s", msg)
+				}
+				if strings.TrimSpace(child.Text) != "" && !slices.Contains(rule.children, "#text") && counts["#text"] == 0 {
+					report(path, "text is not allowed in <%s>", n.Name)
+					counts["#text"]++
+				}
+				continue
+			}
+			counts[child.Name]++
+			childPath := fmt.Sprintf("%s/%s[%d]", path, child.Name, counts[child.Name])
+			if !slices.Contains(rule.children, child.Name) {
+				report(childPath, "<%s> is not allowed in <%s>", child.Name, n.Name)
+				continue
+			}
+			walk(child, childPath)
+		}
+
+		switch n.Name {
+		case "wml":
+			if counts["card"] == 0 {
+				report(path, "a deck needs at least one <card>")
+			}
+			if counts["head"] > 1 || counts["template"] > 1 {
+				report(path, "a deck has at most one <head> and one <template>")
+			}
+		case "card":
+			if counts["timer"] > 1 {
+				report(path, "a card has at most one <timer>")
+			}
+		case "select", "optgroup":
+			if counts["option"]+counts["optgroup"] == 0 {
+				report(path, "<%s> needs at least one <option>", n.Name)
+			}
+		case "table":
+			if counts["tr"] == 0 {
+				report(path, "<table> needs at least one <tr>")
+			}
+		case "tr":
+			if counts["td"] == 0 {
+				report(path, "<tr> needs at least one <td>")
+			}
+		}
+	}
+	walk(d.Root, d.Root.Name)
+
+	for _, l := range links {
+		if !ids[l.target] {
+			report(l.path, "link to unknown card #%s", l.target)
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,153 @@
+package wml
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func validateDeck(t *testing.T, version, cards string) []Violation {
+	t.Helper()
+	deck := fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE wml PUBLIC "-//WAPFORUM//DTD WML %s//EN" "http://www.wapforum.org/DTD/wml_%s.xml">
+<wml>%s</wml>`, version, strings.ReplaceAll(version, ".", ""), cards)
+	d, err := Parse(strings.NewReader(deck))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Version() != version {
+		t.Fatalf("deck version = %s, want %s", d.Version(), version)
+	}
+	return Validate(d)
+}
+
+func TestValidateValid(t *testing.T) {
+	const cards = `
+<template><do type="prev" label="Back"><prev/></do></template>
+<card id="home" title="Home">
+<p>Price: 5$$ for $name, $(name) and $(name:e) or $(q:escape)</p>
+<p><a href="#search">Search</a></p>
+</card>
+<card id="search" title="Search">
+<p><input name="q" title="Search"/></p>
+<do type="accept" label="Go"><go href="/search?q=$(q:u)&amp;n=$n_2"/></do>
+</card>`
+	for _, version := range []string{"1.1", "1.3"} {
+		for _, v := range validateDeck(t, version, cards) {
+			t.Errorf("WML %s: %s", version, v)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		cards   string
+		// want are substrings of the expected violations, in order
+		want []string
+	}{
+		{
+			"unknown element", "1.1",
+			`<card id="a"><p><blink>hi</blink></p></card>`,
+			[]string{"<blink> is not allowed in <p>"},
+		},
+		{
+			"unknown root child", "1.1",
+			`<card id="a"><p>hi</p></card><script/>`,
+			[]string{"<script> is not allowed in <wml>"},
+		},
+		{
+			"1.3 element in 1.1", "1.1",
+			`<card id="a"><pre>code</pre></card>`,
+			[]string{"<pre> is not a WML 1.1 element"},
+		},
+		{
+			"1.3 element in 1.3", "1.3",
+			`<card id="a"><pre xml:space="preserve">code</pre></card>`,
+			nil,
+		},
+		{
+			"1.2 attribute in 1.1", "1.1",
+			`<card id="a"><p><a href="#a" accesskey="1">a</a></p></card>`,
+			[]string{"attribute accesskey needs WML 1.2"},
+		},
+		{
+			"1.3 attribute in 1.2", "1.2",
+			`<card id="a"><do type="accept"><go href="/" cache-control="no-cache"/></do></card>`,
+			[]string{"attribute cache-control needs WML 1.3"},
+		},
+		{
+			"duplicate card id", "1.1",
+			`<card id="a"><p>one</p></card><card id="b"><p>two</p></card><card id="a"><p>three</p></card>`,
+			[]string{`duplicate card id "a"`},
+		},
+		{
+			"link to unknown card", "1.1",
+			`<card id="a" onenterforward="#b"><p><a href="#c">c</a></p></card>`,
+			[]string{"link to unknown card #b", "link to unknown card #c"},
+		},
+		{
+			"missing required attribute", "1.1",
+			`<card id="a"><p><img src="/a.wbmp"/></p></card>`,
+			[]string{"<img> is missing required attribute alt"},
+		},
+		{
+			"enum value", "1.1",
+			`<card id="a"><p align="justify">a</p></card>`,
+			[]string{`attribute align="justify" must be one of left, right, center`},
+		},
+		{
+			"text outside a paragraph", "1.1",
+			`<card id="a">loose</card>`,
+			[]string{"text is not allowed in <card>"},
+		},
+		{
+			"no card", "1.1",
+			`<template/>`,
+			[]string{"a deck needs at least one <card>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkViolations(t, validateDeck(t, tt.version, tt.cards), tt.want)
+		})
+	}
+}
+
+func TestValidateVars(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"costs 5$", "lone $ at the end"},
+		{"costs $5", "$ is not followed by a variable name"},
+		{"costs $ 5", "$ is not followed by a variable name"},
+		{"$(name", "unterminated variable reference"},
+		{"$()", "invalid variable name in $()"},
+		{"$(1st)", "invalid variable name in $(1st)"},
+		{"$(na-me)", "invalid variable name in $(na-me)"},
+		{"$(name:foo)", "unknown conversion in $(name:foo)"},
+		{"$(name:)", "unknown conversion in $(name:)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			// in text
+			checkViolations(t, validateDeck(t, "1.1", `<card id="a"><p>`+tt.text+`</p></card>`), []string{tt.want})
+			// and in an attribute
+			checkViolations(t, validateDeck(t, "1.1", `<card id="a" title="`+tt.text+`"><p>a</p></card>`), []string{"attribute title: " + tt.want})
+		})
+	}
+}
+
+func checkViolations(t *testing.T, got []Violation, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d violations %v, want %d %q", len(got), got, len(want), want)
+	}
+	for i, v := range got {
+		if !strings.Contains(v.Message, want[i]) {
+			t.Errorf("violation %d = %q, want %q", i, v, want[i])
+		}
+	}
+}
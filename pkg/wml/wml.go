@@ -0,0 +1,290 @@
+// Package wml parses rendered WML decks into a small DOM so they can be
+// inspected, rewritten and serialized again
+package wml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type NodeType int
+
+const (
+	ElementNode NodeType = iota
+	TextNode
+)
+
+type Attr struct {
+	Name  string
+	Value string
+}
+
+type Node struct {
+	Type     NodeType
+	Name     string
+	Attrs    []Attr
+	Children []*Node
+	// Text is the unescaped content of a text node
+	Text string
+}
+
+// Document is a parsed deck
+type Document struct {
+	// Encoding is the encoding named in the XML declaration
+	Encoding string
+	// DocType is the full DOCTYPE directive without the surrounding <! >
+	DocType string
+	Root    *Node
+}
+
+// Version returns the WML version named in the DOCTYPE, "1.1" if there is none
+func (d *Document) Version() string {
+	for _, v := range []string{"1.3", "1.2", "1.1"} {
+		if strings.Contains(d.DocType, "WML "+v) {
+			return v
+		}
+	}
+	return "1.1"
+}
+
+// elementOnly lists elements that only contain other elements,
+// whitespace between their children carries no meaning
+var elementOnly = map[string]bool{
+	"wml": true, "head": true, "template": true, "card": true, "do": true,
+	"onevent": true, "select": true, "optgroup": true, "access": true,
+}
+
+// entities defined by the WML DTD on top of the XML ones
+var entities = map[string]string{
+	"nbsp": "\u00a0",
+	"shy":  "\u00ad",
+}
+
+// Parse reads a deck. Our templates declare iso-8859-1 but text/template always
+// writes UTF-8, so the declared encoding is recorded but the input is read as UTF-8
+func Parse(r io.Reader) (*Document, error) {
+	doc := &Document{}
+	dec := xml.NewDecoder(r)
+	dec.Entity = entities
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var stack []*Node
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				doc.Encoding = procInstAttr(string(t.Inst), "encoding")
+			}
+		case xml.Directive:
+			if strings.HasPrefix(string(t), "DOCTYPE") {
+				doc.DocType = string(t)
+			}
+		case xml.StartElement:
+			n := &Node{Type: ElementNode, Name: t.Name.Local}
+			for _, a := range t.Attr {
+				n.Attrs = append(n.Attrs, Attr{Name: attrName(a.Name), Value: a.Value})
+			}
+			if len(stack) == 0 {
+				if doc.Root != nil {
+					return nil, fmt.Errorf("wml: more than one root element")
+				}
+				doc.Root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			parent := stack[len(stack)-1]
+			if elementOnly[parent.Name] && len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+			parent.Children = append(parent.Children, &Node{Type: TextNode, Text: string(t)})
+		}
+	}
+
+	if doc.Root == nil {
+		return nil, fmt.Errorf("wml: empty document")
+	}
+	return doc, nil
+}
+
+// xmlNamespace is what encoding/xml turns the reserved xml: prefix into
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// attrName puts the prefix back on attributes like xml:lang, WML has no
+// namespaces so anything else is kept as written
+func attrName(name xml.Name) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case xmlNamespace:
+		return "xml:" + name.Local
+	default:
+		return name.Space + ":" + name.Local
+	}
+}
+
+func procInstAttr(inst, name string) string {
+	i := strings.Index(inst, name+"=")
+	if i < 0 {
+		return ""
+	}
+	rest := inst[i+len(name)+1:]
+	if len(rest) < 2 {
+		return ""
+	}
+	quote := rest[0]
+	end := strings.IndexByte(rest[1:], quote)
+	if end < 0 {
+		return ""
+	}
+	return rest[1 : end+1]
+}
+
+// Attr returns the value of the named attribute
+func (n *Node) Attr(name string) (string, bool) {
+	for _, a := range n.Attrs {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttr sets or adds the named attribute
+func (n *Node) SetAttr(name, value string) {
+	for i, a := range n.Attrs {
+		if a.Name == name {
+			n.Attrs[i].Value = value
+			return
+		}
+	}
+	n.Attrs = append(n.Attrs, Attr{Name: name, Value: value})
+}
+
+// Clone returns a deep copy of the node
+func (n *Node) Clone() *Node {
+	c := *n
+	c.Attrs = append([]Attr(nil), n.Attrs...)
+	c.Children = make([]*Node, len(n.Children))
+	for i, child := range n.Children {
+		c.Children[i] = child.Clone()
+	}
+	return &c
+}
+
+// Walk calls fn for n and all its descendants, depth first
+func (n *Node) Walk(fn func(*Node)) {
+	fn(n)
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
+
+// Elements returns the child elements named name
+func (n *Node) Elements(name string) []*Node {
+	var out []*Node
+	for _, child := range n.Children {
+		if child.Type == ElementNode && child.Name == name {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+// NewElement creates an element node
+func NewElement(name string, attrs ...Attr) *Node {
+	return &Node{Type: ElementNode, Name: name, Attrs: append([]Attr(nil), attrs...)}
+}
+
+// NewText creates a text node
+func NewText(text string) *Node {
+	return &Node{Type: TextNode, Text: text}
+}
+
+// Bytes serializes the document, characters outside ASCII are written as
+// character references so the result is valid whatever encoding is declared
+func (d *Document) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(`<?xml version="1.0"`)
+	if d.Encoding != "" {
+		fmt.Fprintf(buf, ` encoding="%s"`, d.Encoding)
+	}
+	buf.WriteString("?>\n")
+	if d.DocType != "" {
+		fmt.Fprintf(buf, "<!%s>\n", d.DocType)
+	}
+	d.Root.write(buf)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func (n *Node) write(buf *bytes.Buffer) {
+	if n.Type == TextNode {
+		escape(buf, n.Text, false)
+		return
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(n.Name)
+	for _, a := range n.Attrs {
+		fmt.Fprintf(buf, ` %s="`, a.Name)
+		escape(buf, a.Value, true)
+		buf.WriteByte('"')
+	}
+	if len(n.Children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteByte('>')
+	for _, child := range n.Children {
+		if child.Type == ElementNode && elementOnly[n.Name] {
+			buf.WriteByte('\n')
+		}
+		child.write(buf)
+	}
+	if elementOnly[n.Name] {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("</")
+	buf.WriteString(n.Name)
+	buf.WriteByte('>')
+}
+
+func escape(buf *bytes.Buffer, s string, attr bool) {
+	for _, r := range s {
+		switch {
+		case r == '&':
+			buf.WriteString("&amp;")
+		case r == '<':
+			buf.WriteString("&lt;")
+		case r == '>':
+			buf.WriteString("&gt;")
+		case r == '"' && attr:
+			buf.WriteString("&quot;")
+		case r > 0x7e:
+			fmt.Fprintf(buf, "&#%d;", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serviceStatus is what a section reports on the readiness endpoint
+type serviceStatus struct {
+	// Upstreams are the external endpoints the section is configured to use
+	Upstreams []string `json:"upstreams,omitempty"`
+	// Error is set when the section runs degraded, the instance stays ready
+	// as the other sections still work
+	Error string `json:"error,omitempty"`
+}
+
+type statusReporter interface {
+	status() serviceStatus
+}
+
+// readiness answers load balancer probes, it turns unready as soon as
+// shutdown starts so no new requests are sent while we drain
+type readiness struct {
+	services map[string]statusReporter
+	stopping atomic.Bool
+}
+
+func (r *readiness) serveReady(c echo.Context) error {
+	ready := !r.stopping.Load()
+	services := map[string]serviceStatus{}
+	for name, s := range r.services {
+		services[name] = s.status()
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, struct {
+		Ready    bool                     `json:"ready"`
+		Stopping bool                     `json:"stopping,omitempty"`
+		Services map[string]serviceStatus `json:"services"`
+	}{Ready: ready, Stopping: r.stopping.Load(), Services: services})
+}
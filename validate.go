@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+	"github.com/labstack/echo/v4"
+)
+
+// validateDeck checks every rendered deck against the WML rules and logs what is wrong.
+// In strict mode invalid decks are replaced with an error card, phones tend
+// to show a blank screen or "Invalid content" on a broken deck
+func validateDeck(strict bool, renderer *render.Renderer) wmlFilter {
+	return func(c echo.Context, deck []byte) ([]byte, error) {
+		template, _ := c.Get("template").(string)
+		if template == "" {
+			template = "-"
+		}
+
+		var violations []string
+		doc, err := wml.Parse(bytes.NewReader(deck))
+		if err != nil {
+			violations = append(violations, err.Error())
+		} else {
+			for _, v := range wml.Validate(doc) {
+				violations = append(violations, v.String())
+			}
+		}
+		if len(violations) == 0 {
+			return deck, nil
+		}
+
+		for _, v := range violations {
+			log.Println("invalid WML on", c.Path(), "template", template, v)
+		}
+
+		if !strict {
+			return deck, nil
+		}
+		c.Response().Header().Set("Cache-Control", "no-cache, must-revalidate")
+		return errorCard(renderer, "Sorry, this page could not be shown."), nil
+	}
+}
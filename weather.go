@@ -8,15 +8,51 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
-	"text/template"
 	"time"
 
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/config"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/render"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/text"
 	"github.com/hectormalot/omgo"
 	"github.com/labstack/echo/v4"
 )
 
-var weatherLocation = map[string]string{}
-var weatherLocationLock = sync.RWMutex{}
+// locationNameLength keeps a location on one line of the location list
+const locationNameLength = 30
+
+// weatherService serves the weather section from Open-Meteo
+type weatherService struct {
+	cfg config.Weather
+
+	// locations remembers the names of looked up coordinates
+	locations     map[string]string
+	locationsLock sync.RWMutex
+
+	renderer *render.Renderer
+}
+
+func newWeatherService(cfg config.Weather, renderer *render.Renderer) *weatherService {
+	return &weatherService{cfg: cfg, locations: map[string]string{}, renderer: renderer}
+}
+
+func (s *weatherService) status() serviceStatus {
+	return serviceStatus{Upstreams: []string{s.cfg.GeocodingURL, s.cfg.ForecastURL}}
+}
+
+// client talks to the configured forecast API
+func (s *weatherService) client() omgo.Client {
+	wc, _ := omgo.NewClient()
+	wc.URL = s.cfg.ForecastURL
+	return wc
+}
+
+// locationName returns the name of coordinates picked on the location page
+func (s *weatherService) locationName(id string) (string, bool) {
+	s.locationsLock.RLock()
+	defer s.locationsLock.RUnlock()
+	name, ok := s.locations[id]
+	return name, ok
+}
 
 type WeatherLocation struct {
 	ID          int      `json:"id"`
@@ -46,9 +82,9 @@ type WeatherLocationResult struct {
 	GenerationtimeMs float64           `json:"generationtime_ms"`
 }
 
-func lookUpLocation(name string) ([]WeatherLocation, error) {
+func (s *weatherService) lookUpLocation(name string) ([]WeatherLocation, error) {
 	// do HTTP request to get location
-	resp, err := http.Get(fmt.Sprintf("https://geocoding-api.open-meteo.com/v1/search?name=%s&count=10&language=en&format=json", url.QueryEscape(name)))
+	resp, err := http.Get(fmt.Sprintf("%s?name=%s&count=10&language=en&format=json", s.cfg.GeocodingURL, url.QueryEscape(name)))
 	if err != nil {
 		return nil, err
 	}
@@ -73,34 +109,31 @@ type WeatherLocationPage struct {
 	LocationValue string
 }
 
-func serveWeatherLocation(c echo.Context) error {
-	tmpl := template.Must(template.ParseFiles("./static/weather/location.wml"))
-
+func (s *weatherService) serveLocation(c echo.Context) error {
 	page := WeatherLocationPage{
 		LocationValue: c.QueryParam("loc"),
 	}
 
 	if page.LocationValue != "" {
-		locations, err := lookUpLocation(page.LocationValue)
+		locations, err := s.lookUpLocation(page.LocationValue)
 		if err == nil {
 			locs := []WeatherPageLocation{}
 			for _, loc := range locations {
 				id := fmt.Sprintf("%f,%f", loc.Latitude, loc.Longitude)
 				locs = append(locs, WeatherPageLocation{
 					ID:   id,
-					Name: fmt.Sprintf("%s, %s", loc.Name, loc.Country),
+					Name: text.Truncate(fmt.Sprintf("%s, %s", loc.Name, loc.Country), locationNameLength),
 				})
 
-				weatherLocationLock.Lock()
-				weatherLocation[id] = loc.Name
-				weatherLocationLock.Unlock()
+				s.locationsLock.Lock()
+				s.locations[id] = text.Truncate(loc.Name, locationNameLength)
+				s.locationsLock.Unlock()
 			}
 			page.LocationList = locs
 		}
 	}
 
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-	return tmpl.Execute(c.Response().Writer, page)
+	return s.renderer.Render(c, "weather/location.wml", page)
 }
 
 type WeatherCondition struct {
@@ -179,8 +212,7 @@ func windDirection(dir float64) string {
 	}
 }
 
-func serveWeatherDetailView(c echo.Context) error {
-	tmpl := template.Must(template.ParseFiles("./static/weather/details.wml"))
+func (s *weatherService) serveDetails(c echo.Context) error {
 	locStr := c.QueryParam("loc")
 	if locStr == "" {
 		return c.Redirect(http.StatusFound, "/weather/location")
@@ -193,7 +225,7 @@ func serveWeatherDetailView(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/weather/location")
 	}
 
-	wc, _ := omgo.NewClient()
+	wc := s.client()
 	loc, err := omgo.NewLocation(lat, long)
 	if err != nil {
 		log.Println(err)
@@ -210,9 +242,7 @@ func serveWeatherDetailView(c echo.Context) error {
 	page := WeatherDetailPage{
 		LocationID: locStr,
 	}
-	weatherLocationLock.RLock()
-	locName, ok := weatherLocation[locStr]
-	weatherLocationLock.RUnlock()
+	locName, ok := s.locationName(locStr)
 	if ok {
 		page.Location = locName
 	}
@@ -236,9 +266,7 @@ func serveWeatherDetailView(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/weather/location")
 	}*/
 
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-
-	return tmpl.Execute(c.Response().Writer, page)
+	return s.renderer.Render(c, "weather/details.wml", page)
 }
 
 type WeatherHourlyPage struct {
@@ -248,8 +276,7 @@ type WeatherHourlyPage struct {
 	Data       []WeatherCondition
 }
 
-func serveWeatherHourly(c echo.Context) error {
-	tmpl := template.Must(template.ParseFiles("./static/weather/hourly.wml"))
+func (s *weatherService) serveHourly(c echo.Context) error {
 	locStr := c.QueryParam("loc")
 	if locStr == "" {
 		return c.Redirect(http.StatusFound, "/weather/location")
@@ -270,7 +297,7 @@ func serveWeatherHourly(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/weather/location")
 	}
 
-	wc, _ := omgo.NewClient()
+	wc := s.client()
 	loc, err := omgo.NewLocation(lat, long)
 	if err != nil {
 		log.Println(err)
@@ -287,9 +314,7 @@ func serveWeatherHourly(c echo.Context) error {
 		Data:       []WeatherCondition{},
 		Offset:     offset + 6,
 	}
-	weatherLocationLock.RLock()
-	locName, ok := weatherLocation[locStr]
-	weatherLocationLock.RUnlock()
+	locName, ok := s.locationName(locStr)
 	if ok {
 		page.Location = locName
 	}
@@ -328,9 +353,7 @@ func serveWeatherHourly(c echo.Context) error {
 		})
 	}
 
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-
-	return tmpl.Execute(c.Response().Writer, page)
+	return s.renderer.Render(c, "weather/hourly.wml", page)
 }
 
 type WeatherDailyPage struct {
@@ -340,8 +363,7 @@ type WeatherDailyPage struct {
 	Data       []WeatherCondition
 }
 
-func serveWeatherDaily(c echo.Context) error {
-	tmpl := template.Must(template.ParseFiles("./static/weather/daily.wml"))
+func (s *weatherService) serveDaily(c echo.Context) error {
 	locStr := c.QueryParam("loc")
 	if locStr == "" {
 		return c.Redirect(http.StatusFound, "/weather/location")
@@ -362,7 +384,7 @@ func serveWeatherDaily(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/weather/location")
 	}
 
-	wc, _ := omgo.NewClient()
+	wc := s.client()
 	loc, err := omgo.NewLocation(lat, long)
 	if err != nil {
 		log.Println(err)
@@ -379,9 +401,7 @@ func serveWeatherDaily(c echo.Context) error {
 		Data:       []WeatherCondition{},
 		Offset:     offset + 6,
 	}
-	weatherLocationLock.RLock()
-	locName, ok := weatherLocation[locStr]
-	weatherLocationLock.RUnlock()
+	locName, ok := s.locationName(locStr)
 	if ok {
 		page.Location = locName
 	}
@@ -421,7 +441,5 @@ func serveWeatherDaily(c echo.Context) error {
 		})
 	}
 
-	c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-
-	return tmpl.Execute(c.Response().Writer, page)
+	return s.renderer.Render(c, "weather/daily.wml", page)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/device"
+	"github.com/labstack/echo/v4"
+)
+
+// wmlFilter post-processes a rendered deck before it is sent to the phone
+type wmlFilter func(c echo.Context, deck []byte) ([]byte, error)
+
+// wmlBuffer holds back WML responses so filters can rewrite them,
+// anything else is passed straight through
+type wmlBuffer struct {
+	http.ResponseWriter
+	buf       bytes.Buffer
+	status    int
+	decided   bool
+	buffering bool
+}
+
+func (w *wmlBuffer) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.status = status
+	w.buffering = strings.HasPrefix(w.Header().Get("Content-Type"), "text/vnd.wap.wml")
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *wmlBuffer) Write(b []byte) (int, error) {
+	// our handlers execute templates straight into the writer without a WriteHeader
+	w.WriteHeader(http.StatusOK)
+	if w.buffering {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// wmlMiddleware runs every rendered deck through filters in order,
+// a failing filter is logged and the deck continues unchanged
+func wmlMiddleware(filters ...wmlFilter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := c.Response()
+			original := res.Writer
+			buffer := &wmlBuffer{ResponseWriter: original}
+			res.Writer = buffer
+
+			err := next(c)
+			res.Writer = original
+			if !buffer.buffering {
+				return err
+			}
+
+			deck := buffer.buf.Bytes()
+			for _, filter := range filters {
+				out, ferr := filter(c, deck)
+				if ferr != nil {
+					log.Println("WML filter failed on", c.Request().URL.Path, ferr)
+					continue
+				}
+				deck = out
+			}
+
+			// the charset, compilation and splitting of a deck depend on the phone
+			original.Header().Add("Vary", device.Vary)
+			original.Header().Set("Content-Length", strconv.Itoa(len(deck)))
+			original.WriteHeader(buffer.status)
+			original.Write(deck)
+			return err
+		}
+	}
+}
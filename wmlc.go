@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wbxml"
+	"github.com/bevelgacom/wap.wap.bevelgacom.be/pkg/wml"
+	"github.com/labstack/echo/v4"
+)
+
+// compileWML answers phones that accept compiled WML with WBXML,
+// so WAP 1.x phones work without a gateway doing it for us
+func compileWML(c echo.Context, deck []byte) ([]byte, error) {
+	if !deviceProfile(c).WMLC {
+		return deck, nil
+	}
+
+	doc, err := wml.Parse(bytes.NewReader(deck))
+	if err != nil {
+		return deck, err
+	}
+	out, err := wbxml.Encode(doc, wbxml.Options{Charset: deviceProfile(c).Charset})
+	if err != nil {
+		return deck, err
+	}
+
+	c.Response().Header().Set("Content-Type", wbxml.ContentType)
+	return out, nil
+}
+
+// compiledSize measures decks the way phones count them, as WBXML
+func compiledSize(doc *wml.Document) int {
+	out, err := wbxml.Encode(doc, wbxml.Options{})
+	if err != nil {
+		return wml.EstimateSize(doc)
+	}
+	return len(out)
+}